@@ -2,10 +2,30 @@ package main
 
 import (
 	"ctr"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"lib/uweb"
+	"log"
+	"os"
+)
+
+var (
+	routes = flag.Bool("routes", false, "print route table and exit")
+	format = flag.String("format", "text", "route table format: text or json")
 )
 
 func main() {
+	flag.Parse()
+
+	// route table, for diff in code review
+	if *routes {
+		if err := printRoutes(*format); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// uweb
 	uweb.DEBUG = true
 	uweb.DEVELOPMENT = true
@@ -14,6 +34,8 @@ func main() {
 	app := uweb.NewApp()
 	// hacheck
 	app.Use(uweb.MdIgnore([]string{"/hacheck"}))
+	// readiness, fails before draining on shutdown
+	app.Use(uweb.MdReady("/ready"))
 	// static
 	app.Use(uweb.MdFavicon("../pub/img/favicon.ico"))
 	app.Use(uweb.MdStatic("/public", "../pub")) // before compress
@@ -35,6 +57,8 @@ func main() {
 	app.Use(uweb.MdErrPage(uweb.Map{
 		"404_home_url": "",
 	}))
+	// panic to 500, after error page
+	app.Use(uweb.MdRecover(nil))
 	// @ctrl/auth.go
 	app.Use(ctrl.MdAuth())
 	// router
@@ -42,3 +66,18 @@ func main() {
 	// listen
 	app.Listen(":8888")
 }
+
+func printRoutes(format string) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(uweb.Routes(), "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(append(data, '\n'))
+		return err
+	case "text":
+		return uweb.PrintRoutes(os.Stdout, uweb.Routes())
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
@@ -7,9 +7,9 @@ import (
 )
 
 func init() {
-	uweb.Get("/index", Index)
-	uweb.Get("/lcq", Lcq)
-	uweb.Post("/login", Login)
+	uweb.Get("/index", Index).Name("index")
+	uweb.Get("/lcq", Lcq).Name("lcq")
+	uweb.Post("/login", Login).Name("login")
 }
 func CsrfToken(c *uweb.Context) string {
 	return c.Sess.Get(uweb.CSRF_TOKEN_KEY)
@@ -57,14 +57,15 @@ func (h *mdAuth) Handle(c *uweb.Context) int {
 		return uweb.NEXT_CONTINUE
 	}
 	// root
+	index, _ := uweb.URL("index")
 	if p == "/" {
-		c.Res.Redirect(p, "/index")
+		c.Res.Redirect(p, index)
 		return uweb.NEXT_BREAK
 	}
 	// 过滤条件
-	if 1 == 1 && p != "/index" {
+	if 1 == 1 && p != index {
 		//c.Res.Header().Set("xxxPage", "/xxx/xxx")
-		c.Res.Redirect(p, "/index")
+		c.Res.Redirect(p, index)
 		return uweb.NEXT_BREAK
 	}
 	return uweb.NEXT_CONTINUE
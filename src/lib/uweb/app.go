@@ -1,6 +1,7 @@
 package uweb
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"sync"
@@ -23,10 +24,18 @@ var (
 	// Maxium middlewares
 	MAX_MIDDLEWARE = 32
 	
-	// Grace timeout seconds.
-	// If use supervisor, set: stopwaitsecs=GRACE_TIMEOUT_SECONDS+2.
-	// DEBUG mode no grace shutdown.
+	// Grace timeout seconds, for draining, and for shutdown hooks.
+	// If use supervisor, set:
+	// stopwaitsecs=GRACE_DRAIN_SECONDS+GRACE_TIMEOUT_SECONDS*2+2.
 	GRACE_TIMEOUT_SECONDS = 10
+
+	// Seconds MdReady fails before draining, longer than
+	// the health check interval of load balancer.
+	GRACE_DRAIN_SECONDS = 0
+
+	// Max connections accepted at the same time, 0 for no limit,
+	// more wait in listen backlog.
+	GRACE_LISTEN_LIMIT = 0
 )
 
 //
@@ -34,8 +43,9 @@ var (
 // store global objects, such as middleware
 //
 type Application struct {
-	mws  []Middleware // all middlewares
-	pool sync.Pool    // cache Context
+	mws   []Middleware   // all middlewares
+	pool  sync.Pool      // cache Context
+	hooks []shutdownHook // run by Server after drain
 }
 
 // Create empty application without any middleware
@@ -60,20 +70,25 @@ func (a *Application) Use(m Middleware) {
 	a.mws = append(a.mws, m)
 }
 
-// Listen and start serve
+// Add a shutdown hook, see Server.OnShutdown
+func (a *Application) OnShutdown(name string, f func(ctx context.Context) error) {
+	a.hooks = append(a.hooks, shutdownHook{name, f})
+}
+
+// Listen and start serve, shutdown gracefully
 func (a *Application) Listen(addr string) error {
-	if DEBUG {
-		log.Println(LOG_TAG, "Application: Listen at", addr, "(DEBUG)")
-		// no grace shutdown in debug mode
-		return http.ListenAndServe(addr, a)		
-	}
-	
 	srv := &Server{
-		Timeout: time.Duration(GRACE_TIMEOUT_SECONDS) * time.Second,
-		Server: &http.Server{Addr:addr, Handler: a},
+		Timeout:     time.Duration(GRACE_TIMEOUT_SECONDS) * time.Second,
+		DrainDelay:  time.Duration(GRACE_DRAIN_SECONDS) * time.Second,
+		ListenLimit: GRACE_LISTEN_LIMIT,
+		Server:      &http.Server{Addr: addr, Handler: a},
+		Logger:      DefaultLogger(),
+	}
+	for _, h := range a.hooks {
+		srv.OnShutdown(h.name, h.f)
 	}
-	log.Println(LOG_TAG, "Application: Listen at", addr, "(GRACE)")
-	return srv.ListenAndServe()	
+	log.Println(LOG_TAG, "Application: Listen at", addr)
+	return srv.ListenAndServe()
 }
 
 // Handle all http request
@@ -81,15 +96,25 @@ func (a *Application) Listen(addr string) error {
 func (a *Application) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// get c
 	c := a.pool.Get().(*Context)
+	c.acquire()
+
+	// put c, do not forget reset before put,
+	// also when a panic is not recovered by MdRecover.
+	// In DEBUG mode it's dropped, so stale use can be detected.
+	defer func() {
+		c.release()
+		if !DEBUG {
+			a.pool.Put(c)
+		}
+	}()
 
 	// run all middlewares and end the response
+	c.mws = a.mws
 	c.Req = NewRequest(req)
 	c.Res = NewResponse(w)
+	c.Res.c = c
 	if c.Next() != NEXT_ABORT {
 		c.Res.End(c.Req)
 	}
-
-	// put c, do not forget reset before put
-	c.Reset()
-	a.pool.Put(c)
+	c.Res.finish()
 }
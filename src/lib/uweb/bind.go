@@ -0,0 +1,397 @@
+package uweb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// max memory of multipart form, the rest is in temp files
+	MULTIPART_MEMORY int64 = 32 << 20
+
+	ErrBindTarget = errors.New("Bind: dst should be pointer to struct")
+	ErrMediaType  = &StatusError{415, errors.New("Bind: unsupported content type")}
+)
+
+// -----------------------------------------------------------------------------
+// errors
+
+//
+// Error of one field, Field is the json or form name,
+// dotted for nested struct, eg. "address.city"
+//
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+//
+// All field errors of Bind or Validate, returned from handler
+// it's 422, and Response writes it as json
+//
+type ValidationErrors []*FieldError
+
+func (es ValidationErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return "Validate: " + strings.Join(msgs, "; ")
+}
+
+// @impl HttpStatuser
+func (es ValidationErrors) HttpStatus() int {
+	return 422
+}
+
+// -----------------------------------------------------------------------------
+// bind
+
+//
+// Decode body into dst by Content-Type, json, form-urlencoded or
+// multipart, query for requests without body, then Validate it.
+// Form fields are named by `form` tag, or `json` tag, or field name.
+// Multipart files bind to *multipart.FileHeader or a slice of them.
+//
+//	type signup struct {
+//		Name  string `json:"name" validate:"required,min=3,max=20"`
+//		Email string `json:"email" validate:"required,email"`
+//	}
+//	var s signup
+//	if err := c.Req.Bind(&s); err != nil {
+//		return 422, err
+//	}
+//
+func (r *Request) Bind(dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return ErrBindTarget
+	}
+
+	// decode
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var errs ValidationErrors
+	switch {
+	case ct == "application/json" || strings.HasSuffix(ct, "+json"):
+		if err := json.NewDecoder(r.Body).Decode(dst); err != nil {
+			var te *json.UnmarshalTypeError
+			if !errors.As(err, &te) {
+				return &StatusError{400, fmt.Errorf("Bind: %v", err)}
+			}
+			errs = append(errs, &FieldError{te.Field, "type", te.Type.String(), "should be " + te.Type.String()})
+		}
+	case ct == "multipart/form-data":
+		if err := r.ParseMultipartForm(MULTIPART_MEMORY); err != nil {
+			return &StatusError{400, fmt.Errorf("Bind: %v", err)}
+		}
+		errs = bindForm(v.Elem(), r.Form, r.MultipartForm.File)
+	case ct == "application/x-www-form-urlencoded" || len(ct) == 0:
+		if err := r.ParseForm(); err != nil {
+			return &StatusError{400, fmt.Errorf("Bind: %v", err)}
+		}
+		errs = bindForm(v.Elem(), r.Form, nil)
+	default:
+		return ErrMediaType
+	}
+
+	// validate, skip fields failed to decode
+	if err := validateStruct(v.Elem(), ""); err != nil {
+		bad := make(map[string]bool, len(errs))
+		for _, e := range errs {
+			bad[e.Field] = true
+		}
+		for _, e := range err.(ValidationErrors) {
+			if !bad[e.Field] {
+				errs = append(errs, e)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+var (
+	fileHeaderType  = reflect.TypeOf((*multipart.FileHeader)(nil))
+	fileHeadersType = reflect.TypeOf([]*multipart.FileHeader(nil))
+	timeType        = reflect.TypeOf(time.Time{})
+)
+
+// bind form values to struct fields, conversion errors are collected
+func bindForm(v reflect.Value, form url.Values, files map[string][]*multipart.FileHeader) ValidationErrors {
+	var errs ValidationErrors
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf, fv := t.Field(i), v.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			errs = append(errs, bindForm(fv, form, files)...)
+			continue
+		}
+		name := fieldName(sf, "form")
+		if name == "-" {
+			continue
+		}
+
+		// files
+		switch sf.Type {
+		case fileHeaderType:
+			if fhs := files[name]; len(fhs) > 0 {
+				fv.Set(reflect.ValueOf(fhs[0]))
+			}
+			continue
+		case fileHeadersType:
+			if fhs := files[name]; len(fhs) > 0 {
+				fv.Set(reflect.ValueOf(fhs))
+			}
+			continue
+		}
+
+		// values
+		vals, ok := form[name]
+		if !ok || len(vals) == 0 {
+			continue
+		}
+		if err := setField(fv, vals); err != nil {
+			errs = append(errs, &FieldError{name, "type", sf.Type.String(), "should be " + sf.Type.String()})
+		}
+	}
+	return errs
+}
+
+// set field from form strings
+func setField(fv reflect.Value, vals []string) error {
+	switch fv.Kind() {
+	case reflect.Ptr:
+		nv := reflect.New(fv.Type().Elem())
+		if err := setField(nv.Elem(), vals); err != nil {
+			return err
+		}
+		fv.Set(nv)
+		return nil
+	case reflect.Slice:
+		sv := reflect.MakeSlice(fv.Type(), len(vals), len(vals))
+		for i, s := range vals {
+			if err := setField(sv.Index(i), []string{s}); err != nil {
+				return err
+			}
+		}
+		fv.Set(sv)
+		return nil
+	}
+
+	s := vals[0]
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		if s == "on" {
+			s = "true"
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	case reflect.Struct:
+		if fv.Type() != timeType {
+			return errors.New("Bind: unsupported struct")
+		}
+		tm, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(tm))
+	default:
+		return fmt.Errorf("Bind: unsupported type %s", fv.Type())
+	}
+	return nil
+}
+
+// name by tag, then json tag, then field name
+func fieldName(sf reflect.StructField, tag string) string {
+	for _, k := range []string{tag, "json"} {
+		if name, _, _ := strings.Cut(sf.Tag.Get(k), ","); len(name) > 0 {
+			return name
+		}
+	}
+	return sf.Name
+}
+
+// -----------------------------------------------------------------------------
+// validate
+
+//
+// Validate struct fields by `validate` tag, rules are:
+//
+//	required  - not zero value
+//	min=n     - length of string, slice or map, or number >= n
+//	max=n     - length or number <= n
+//	len=n     - length == n
+//	email     - email address
+//	url       - absolute url
+//	oneof=a b - one of the space separated values
+//
+// Other rules are skipped for zero values if not required. Nested
+// structs are validated too. It returns ValidationErrors if any.
+//
+func Validate(v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return ErrBindTarget
+	}
+	return validateStruct(rv, "")
+}
+
+func validateStruct(v reflect.Value, prefix string) error {
+	var errs ValidationErrors
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf, fv := t.Field(i), v.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			if err := validateStruct(fv, prefix); err != nil {
+				errs = append(errs, err.(ValidationErrors)...)
+			}
+			continue
+		}
+		name := prefix + fieldName(sf, "json")
+
+		// rules
+		if tag := sf.Tag.Get("validate"); len(tag) > 0 && tag != "-" {
+			if e := validateField(fv, name, tag); e != nil {
+				errs = append(errs, e)
+				continue
+			}
+		}
+
+		// nested
+		sv := reflect.Indirect(fv)
+		if sv.Kind() == reflect.Struct && sv.Type() != timeType {
+			if err := validateStruct(sv, name+"."); err != nil {
+				errs = append(errs, err.(ValidationErrors)...)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// first failed rule of field
+func validateField(fv reflect.Value, name, tag string) *FieldError {
+	rules := strings.Split(tag, ",")
+	zero := fv.IsZero()
+	for _, rule := range rules {
+		if rule == "required" {
+			if zero {
+				return &FieldError{name, rule, "", "is required"}
+			}
+		}
+	}
+	if zero {
+		return nil
+	}
+
+	v := reflect.Indirect(fv)
+	for _, rule := range rules {
+		rule, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch rule {
+		case "", "required":
+		case "min", "max", "len":
+			n, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				panic(fmt.Sprintf("Validate: bad param of %s on %s", rule, name))
+			}
+			size, isLen := validateSize(v)
+			ok := (rule == "min" && size >= n) || (rule == "max" && size <= n) || (rule == "len" && size == n)
+			if !ok {
+				what := "should be"
+				if isLen {
+					what = "length should be"
+				}
+				op := map[string]string{"min": ">=", "max": "<=", "len": "=="}[rule]
+				return &FieldError{name, rule, param, fmt.Sprintf("%s %s %s", what, op, param)}
+			}
+		case "email":
+			if a, err := mail.ParseAddress(v.String()); err != nil || a.Address != v.String() {
+				return &FieldError{name, rule, "", "should be an email"}
+			}
+		case "url":
+			if u, err := url.Parse(v.String()); err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+				return &FieldError{name, rule, "", "should be an url"}
+			}
+		case "oneof":
+			s := fmt.Sprint(v.Interface())
+			found := false
+			for _, o := range strings.Fields(param) {
+				if o == s {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return &FieldError{name, rule, param, "should be one of " + param}
+			}
+		default:
+			panic(fmt.Sprintf("Validate: unknown rule %s on %s", rule, name))
+		}
+	}
+	return nil
+}
+
+// length of string, slice or map, or number
+func validateSize(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(len([]rune(v.String()))), true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false
+	}
+	return 0, false
+}
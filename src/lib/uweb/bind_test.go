@@ -0,0 +1,95 @@
+package uweb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type testSignup struct {
+	Name    string       `json:"name" validate:"required,min=3,max=20"`
+	Email   string       `json:"email" validate:"required,email"`
+	Age     int          `json:"age" form:"age" validate:"min=18"`
+	Role    string       `json:"role" validate:"oneof=admin user"`
+	Tags    []string     `json:"tags" validate:"max=2"`
+	Address *testAddress `json:"address"`
+}
+
+func TestBind(t *testing.T) {
+	tests := []struct {
+		ct, body string
+		fields   string // failed fields, sorted by struct order
+	}{
+		{"application/json", `{"name":"lcq","email":"a@b.com","age":20,"role":"user","address":{"city":"sz"}}`, ""},
+		{"application/json", `{"name":"lc","email":"a@","age":3,"role":"root","tags":["a","b","c"],"address":{}}`, "name,email,age,role,tags,address.city"},
+		{"application/json", `{"name":"lcq","email":"a@b.com","age":"x"}`, "age"},
+		{"application/x-www-form-urlencoded", "name=lcq&email=a%40b.com&age=20&tags=a&tags=b", ""},
+		{"application/x-www-form-urlencoded", "name=lcq&email=a%40b.com&age=x", "age"},
+		{"application/x-www-form-urlencoded", "", "name,email"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "http://localhost/signup", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.ct)
+		var s testSignup
+		err := NewRequest(req).Bind(&s)
+		var fields []string
+		if err != nil {
+			ve, ok := err.(ValidationErrors)
+			if !ok {
+				t.Errorf("%s: %v", tt.body, err)
+				continue
+			}
+			for _, e := range ve {
+				fields = append(fields, e.Field)
+			}
+		}
+		if got := strings.Join(fields, ","); got != tt.fields {
+			t.Errorf("%s: failed %q, want %q", tt.body, got, tt.fields)
+		}
+	}
+}
+
+func TestBindResponse(t *testing.T) {
+	r := NewRouter()
+	r.Post("/signup", func(c *Context) (int, error) {
+		var s testSignup
+		if err := c.Req.Bind(&s); err != nil {
+			return 400, err
+		}
+		return 201, c.Res.Json(s)
+	})
+
+	// validation errors are 422 json
+	req, _ := http.NewRequest("POST", "http://localhost/signup", strings.NewReader(`{"name":"lcq"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	app := NewApp()
+	app.Use(r)
+	app.ServeHTTP(w, req)
+	var body struct {
+		Errors []FieldError `json:"errors"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != 422 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if len(body.Errors) != 1 || body.Errors[0].Field != "email" || body.Errors[0].Rule != "required" {
+		t.Errorf("errors %+v", body.Errors)
+	}
+
+	// bad json and media type
+	for ct, code := range map[string]int{"application/json": 400, "text/csv": 415} {
+		req, _ = http.NewRequest("POST", "http://localhost/signup", strings.NewReader(`{`))
+		req.Header.Set("Content-Type", ct)
+		w = httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		if w.Code != code {
+			t.Errorf("%s: got %d, want %d", ct, w.Code, code)
+		}
+	}
+}
@@ -62,6 +62,7 @@ func (m *MemCache) Name() string {
 // @impl Middleware
 func (m *MemCache) Handle(c *Context) int {
 	c.Cache = m
+	c.Set(CTX_CACHE_KEY, c.Cache)
 	return NEXT_CONTINUE
 }
 
@@ -79,6 +80,23 @@ func (m *MemCache) Get(key string) ([]byte, error) {
 	return item.Value, nil
 }
 
+// Increase key by 1, created with expire if not exist
+func (m *MemCache) Incr(key string, expire int) (int64, error) {
+	key = m.prefix + key
+	n, err := m.mc.Increment(key, 1)
+	if err == memcache.ErrCacheMiss {
+		err = m.mc.Add(&memcache.Item{Key: key, Value: []byte("1"), Expiration: int32(expire)})
+		if err == nil {
+			return 1, nil
+		}
+		// added by others
+		if err == memcache.ErrNotStored {
+			n, err = m.mc.Increment(key, 1)
+		}
+	}
+	return int64(n), err
+}
+
 /*
 
 //
@@ -128,6 +146,7 @@ func NewRedisCache(dsn string) (*RedisCache, error) {
 // @impl Middleware
 func (r *RedisCache) Handle(c *Context) int {
 	c.Cache = r
+	c.Set(CTX_CACHE_KEY, c.Cache)
 	return NEXT_CONTINUE
 }
 
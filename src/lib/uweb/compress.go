@@ -32,6 +32,15 @@ func (g *gzipWriter) Write(data []byte) (int, error) {
 	return g.w.Write(data)
 }
 
+// flush compressed data, for streams
+// @impl http.Flusher
+func (g *gzipWriter) Flush() {
+	g.w.Flush()
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 //
 // Gzip compress
 //
@@ -62,8 +71,23 @@ func (g *Gzip) Handle(c *Context) int {
 	if c.Res.Err != nil {
 		return NEXT_CONTINUE
 	}
-	// small body
-	if len(c.Res.Body) < GZIP_THRESHOLD {
+	// written by handler, eg. event stream
+	if c.Res.sent() {
+		return NEXT_CONTINUE
+	}
+	// file may be compressed already, and Range
+	// does not work on compressed data
+	if c.Res.file != nil {
+		return NEXT_CONTINUE
+	}
+	// stream is compressed on the fly, but it can't be sniffed
+	// after compressed, so Content-Type must be set
+	if c.Res.stream != nil {
+		if len(c.Res.Header().Get("Content-Type")) == 0 {
+			return NEXT_CONTINUE
+		}
+	} else if len(c.Res.Body) < GZIP_THRESHOLD {
+		// small body
 		return NEXT_CONTINUE
 	}
 	// empty status
@@ -78,7 +102,7 @@ func (g *Gzip) Handle(c *Context) int {
 
 	// set headers
 	h := c.Res.Header()
-	h.Set("Vary", "Accept-Encoding")
+	addVary(c.Res, "Accept-Encoding")
 	h.Set("Content-Encoding", "gzip")
 	h.Del("Content-Length")
 
@@ -111,6 +135,11 @@ func (g *Gzip) bypass(req *Request) bool {
 		return true
 	}
 
+	// ignore event stream, it must be flushed as it goes
+	if strings.Contains(req.Header.Get("Accept"), "text/event-stream") {
+		return true
+	}
+
 	// ignore websocket
 	if len(req.Header.Get("Sec-WebSocket-Key")) > 0 {
 		return true
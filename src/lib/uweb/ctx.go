@@ -1,11 +1,48 @@
 package uweb
 
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrContextRecycled = errors.New("Context: used after request finished, use Copy() in goroutines")
+)
+
+// Keys of values set by builtin middlewares, besides the
+// Context fields, so third-party middlewares can use them, e.g.
+//
+//	sess, ok := uweb.ValueAs[*uweb.Session](c, uweb.CTX_SESS_KEY)
+//
+// Own keys should be prefixed to avoid collisions.
+const (
+	// Cache, set by MdCache
+	CTX_CACHE_KEY = "uweb.cache"
+
+	// *Session, set by MdSession
+	CTX_SESS_KEY = "uweb.sess"
+
+	// *Flash, set by MdFlash
+	CTX_FLASH_KEY = "uweb.flash"
+
+	// *Locale, set by MdI18n
+	CTX_LOCALE_KEY = "uweb.locale"
+
+	// Render, set by MdRender
+	CTX_RENDER_KEY = "uweb.render"
+
+	// current user, not set by uweb, for auth middlewares
+	CTX_USER_KEY = "uweb.user"
+)
+
 //
 // Per request context
 //
 type Context struct {
 	// middleware
 	app    *Application
+	mws    []Middleware // current chain, app's or route group's
 	cursor int
 
 	// req & res
@@ -20,6 +57,12 @@ type Context struct {
 	// view
 	Locale *Locale
 	Render Render
+
+	// values passed from middlewares to handlers
+	values map[string]interface{}
+
+	// bumped on recycle and reuse, odd means recycled
+	gen uint32
 }
 
 // Create empty context, need middleware to
@@ -31,30 +74,163 @@ func NewContext(app *Application) *Context {
 	}
 }
 
-// Reset fields for recycle and reuse
+// Reset fields for recycle and reuse,
+// every field set per request must be cleared here
 func (c *Context) Reset() {
+	c.mws = nil
 	c.cursor = -1
 
 	c.Req = nil
 	c.Res = nil
 
+	c.Cache = nil
 	c.Sess = nil
 	c.Flash = nil
 
 	c.Locale = nil
+	c.Render = nil
+
+	// keep map for next request
+	for k := range c.values {
+		delete(c.values, k)
+	}
+}
+
+// Taken from pool for a request
+func (c *Context) acquire() {
+	if c.gen&1 == 1 {
+		c.gen++
+	}
+}
+
+// Request finished, reset it for pool. In DEBUG mode, the
+// app does not reuse it, so a stale use panics in check.
+func (c *Context) release() {
+	c.Reset()
+	c.gen++
+}
+
+// Panic if used after request finished, DEBUG mode only
+func (c *Context) check() {
+	if DEBUG && c.gen&1 == 1 {
+		panic(ErrContextRecycled)
+	}
+}
+
+// Copy for using in goroutines after the request finished.
+// Res is nil, since response is done when handler returns,
+// and Ctx() is not canceled with the request any more.
+// Sess, Flash etc are shared, do not modify them.
+func (c *Context) Copy() *Context {
+	c.check()
+	cp := &Context{
+		app:    c.app,
+		cursor: -1,
+		Cache:  c.Cache,
+		Sess:   c.Sess,
+		Flash:  c.Flash,
+		Locale: c.Locale,
+		Render: c.Render,
+	}
+	if c.Req != nil {
+		req := *c.Req
+		req.Request = c.Req.WithContext(context.WithoutCancel(c.Req.Context()))
+		req.Params = make(Params, len(c.Req.Params))
+		for k, v := range c.Req.Params {
+			req.Params[k] = v
+		}
+		cp.Req = &req
+	}
+	if len(c.values) > 0 {
+		cp.values = make(map[string]interface{}, len(c.values))
+		for k, v := range c.values {
+			cp.values[k] = v
+		}
+	}
+	return cp
+}
+
+// Go context of request, canceled when client goes away,
+// and has deadline if MdDeadline is used. Pass it to db calls.
+func (c *Context) Ctx() context.Context {
+	c.check()
+	return c.Req.Context()
+}
+
+// Set value for later middlewares and handler
+func (c *Context) Set(key string, value interface{}) {
+	c.check()
+	if c.values == nil {
+		c.values = make(map[string]interface{})
+	}
+	c.values[key] = value
+}
+
+// Get value and whether it's set
+func (c *Context) Get(key string) (interface{}, bool) {
+	c.check()
+	v, ok := c.values[key]
+	return v, ok
+}
+
+// Get value, nil if not set
+func (c *Context) Value(key string) interface{} {
+	c.check()
+	return c.values[key]
+}
+
+// Get value, panic if not set, for values that
+// a required middleware must have set
+func (c *Context) MustGet(key string) interface{} {
+	v, ok := c.Get(key)
+	if !ok {
+		panic(fmt.Sprintf("Context: key %q not set", key))
+	}
+	return v
+}
+
+// Get string value, "" if not set or not string
+func (c *Context) GetString(key string) string {
+	v, _ := c.Value(key).(string)
+	return v
+}
+
+// Get int value, 0 if not set or not int
+func (c *Context) GetInt(key string) int {
+	v, _ := c.Value(key).(int)
+	return v
+}
+
+// Get int64 value, 0 if not set or not int64
+func (c *Context) GetInt64(key string) int64 {
+	v, _ := c.Value(key).(int64)
+	return v
+}
+
+// Get bool value, false if not set or not bool
+func (c *Context) GetBool(key string) bool {
+	v, _ := c.Value(key).(bool)
+	return v
+}
+
+// Get value as T, ok is false if not set or of other type
+func ValueAs[T any](c *Context, key string) (T, bool) {
+	v, ok := c.Value(key).(T)
+	return v, ok
 }
 
 // Next run next middlewares or break out all if
 // one return false
 func (c *Context) Next() int {
+	c.check()
 	ret := NEXT_BREAK
-	s := len(c.app.mws)
+	s := len(c.mws)
 	for {
 		c.cursor++
 		if c.cursor >= s {
 			break
 		}
-		md := c.app.mws[c.cursor]
+		md := c.mws[c.cursor]
 		ret = md.Handle(c)
 		if ret != NEXT_CONTINUE {
 			c.cursor = s // will break on next iter
@@ -62,3 +238,13 @@ func (c *Context) Next() int {
 	}
 	return ret
 }
+
+// Run a sub chain of middlewares, such as route group,
+// with the same Next semantics, then go back to current chain
+func (c *Context) run(mws []Middleware) int {
+	mws0, cursor0 := c.mws, c.cursor
+	c.mws, c.cursor = mws, -1
+	ret := c.Next()
+	c.mws, c.cursor = mws0, cursor0
+	return ret
+}
@@ -0,0 +1,86 @@
+package uweb
+
+import (
+	"testing"
+)
+
+type testUser struct {
+	name string
+}
+
+func TestContextValues(t *testing.T) {
+	c := NewContext(nil)
+
+	// unset
+	if v, ok := c.Get("nil"); ok || v != nil {
+		t.Fatal("unset", v, ok)
+	}
+	if c.GetString("nil") != "" || c.GetInt("nil") != 0 || c.GetBool("nil") {
+		t.Fatal("unset typed")
+	}
+
+	// typed
+	c.Set("s", "str")
+	c.Set("i", 42)
+	c.Set("b", true)
+	c.Set(CTX_USER_KEY, &testUser{"lcq"})
+	if c.GetString("s") != "str" || c.GetInt("i") != 42 || !c.GetBool("b") {
+		t.Fatal("typed", c.values)
+	}
+	if c.GetString("i") != "" || c.GetInt64("i") != 0 {
+		t.Fatal("wrong type should be zero")
+	}
+	if u, ok := ValueAs[*testUser](c, CTX_USER_KEY); !ok || u.name != "lcq" {
+		t.Fatal("ValueAs", u, ok)
+	}
+	if _, ok := ValueAs[string](c, CTX_USER_KEY); ok {
+		t.Fatal("ValueAs wrong type")
+	}
+
+	// reset keeps map but clears values
+	c.Reset()
+	if _, ok := c.Get("s"); ok || len(c.values) != 0 {
+		t.Fatal("reset", c.values)
+	}
+
+	// must
+	defer func() {
+		if recover() == nil {
+			t.Fatal("MustGet should panic")
+		}
+	}()
+	c.MustGet(CTX_USER_KEY)
+}
+
+func TestContextLifecycle(t *testing.T) {
+	var stale, cp *Context
+	r := NewRouter()
+	r.Get("/users/:id", func(c *Context) (int, error) {
+		c.Set(CTX_USER_KEY, &testUser{"lcq"})
+		c.Cache = new(MemCache)
+		stale, cp = c, c.Copy()
+		return 200, c.Res.Plain("ok")
+	})
+
+	// every field cleared on recycle
+	testServe(r, "GET", "http://localhost/users/42")
+	if stale.Req != nil || stale.Res != nil || stale.Cache != nil || stale.Render != nil || len(stale.values) != 0 {
+		t.Fatalf("stale fields not reset: %+v", stale)
+	}
+
+	// copy outlives the request
+	if cp.Req.Params["id"] != "42" || cp.Ctx().Err() != nil {
+		t.Fatal("copy req", cp.Req.Params, cp.Ctx().Err())
+	}
+	if u, _ := ValueAs[*testUser](cp, CTX_USER_KEY); u == nil || u.name != "lcq" {
+		t.Fatal("copy values", cp.values)
+	}
+
+	// stale use panics in DEBUG mode
+	defer func() {
+		if err := recover(); err != ErrContextRecycled {
+			t.Fatal("stale use", err)
+		}
+	}()
+	stale.Set("k", "v")
+}
@@ -0,0 +1,66 @@
+package uweb
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// status for handler returned after deadline
+	DEADLINE_STATUS = 503
+
+	ErrDeadline = errors.New("Deadline: handler returned after deadline")
+)
+
+//
+// Create deadline middleware.
+//
+// It sets deadline to c.Ctx(), handlers should pass it down to db or
+// cache calls and return early when it is done. The deadline is
+// cooperative, a handler ignoring ctx is not cut off, the client
+// waits until it returns, then gets DEADLINE_STATUS.
+//
+func MdDeadline(d time.Duration) Middleware {
+	if d <= 0 {
+		panic("Deadline: d <= 0")
+	}
+	return &Deadline{
+		d: d,
+	}
+}
+
+//
+// Deadline sets request deadline
+//
+type Deadline struct {
+	d time.Duration
+}
+
+func (dl *Deadline) Name() string {
+	return "deadline"
+}
+
+// @impl Middleware
+func (dl *Deadline) Handle(c *Context) int {
+	ctx, cancel := context.WithTimeout(c.Ctx(), dl.d)
+	defer cancel()
+	req := c.Req.Request
+	c.Req.Request = c.Req.WithContext(ctx)
+
+	// next
+	c.Next()
+
+	// a stream runs in Response.End after us, the deadline
+	// does not apply to it, and it should not see canceled ctx
+	c.Req.Request = req
+
+	// returned after deadline
+	if ctx.Err() == context.DeadlineExceeded {
+		c.Res.Status = DEADLINE_STATUS
+		c.Res.Err = ErrDeadline
+		c.Res.discard()
+		return NEXT_BREAK
+	}
+	return NEXT_CONTINUE
+}
@@ -0,0 +1,52 @@
+package uweb
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeadline(t *testing.T) {
+	r := NewRouter()
+	r.Get("/wait", func(c *Context) (int, error) {
+		<-c.Ctx().Done()
+		return 200, c.Res.Plain("late")
+	})
+	// ignores ctx, not cut off, but not 200 either
+	r.Get("/sleep", func(c *Context) (int, error) {
+		time.Sleep(100 * time.Millisecond)
+		return 200, c.Res.Plain("late")
+	})
+	r.Get("/stream", func(c *Context) (int, error) {
+		return 200, c.Res.Stream(func(w io.Writer) error {
+			if err := c.Ctx().Err(); err != nil {
+				return err
+			}
+			_, err := io.WriteString(w, "rows")
+			return err
+		})
+	})
+	app := NewApp()
+	app.Use(MdDeadline(50 * time.Millisecond))
+	app.Use(r)
+
+	tests := []struct {
+		url  string
+		code int
+		body string
+	}{
+		{"/wait", DEADLINE_STATUS, ""},
+		{"/sleep", DEADLINE_STATUS, ""},
+		{"/stream", 200, "rows"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "http://localhost"+tt.url, nil)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		if w.Code != tt.code || (tt.body != "" && w.Body.String() != tt.body) || w.Body.String() == "late" {
+			t.Errorf("%s: got %d %q", tt.url, w.Code, w.Body.String())
+		}
+	}
+}
@@ -1,9 +1,41 @@
 package uweb
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 )
 
+//
+// Error with http status, returned from handler, the status
+// overrides the returned one, eg. ErrNotAcceptable
+//
+type StatusError struct {
+	Status int
+	Err    error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// @impl HttpStatuser
+func (e *StatusError) HttpStatus() int {
+	return e.Status
+}
+
+//
+// Error with http status, such as StatusError and ValidationErrors,
+// handler returns it, the status overrides the returned one
+//
+type HttpStatuser interface {
+	HttpStatus() int
+}
+
 //
 // error pages
 //
@@ -14,7 +46,8 @@ func MdErrPage(data Map) Middleware {
 }
 
 //
-// support 404
+// support 404, html page errors/404 for browsers,
+// and json or xml for api clients by Accept
 //
 type errPage struct {
 	data Map
@@ -29,13 +62,27 @@ func (e *errPage) Handle(c *Context) int {
 		return NEXT_CONTINUE
 	}
 	c.Next()
-	
-	if c.Res.Status >= 400 {
+
+	if c.Res.Status >= 400 && !c.Res.final {
+		// copy, e.data is shared by requests
+		status := c.Res.Status
+		data := Map{"status": status}
+		data.Merge(e.data)
 		if c.Res.Err != nil {
-			e.data["error"] = c.Res.Err.Error()
+			data["error"] = c.Res.Err.Error()
+			var ve ValidationErrors
+			if errors.As(c.Res.Err, &ve) {
+				data["errors"] = ve
+			}
 			c.Res.Err = nil
 		}
-		c.Render.Html(fmt.Sprintf("errors/%d", c.Res.Status), e.data)
+		if err := c.Res.Negotiate(status, data, &NegotiateOpts{
+			Html: fmt.Sprintf("errors/%d", status),
+		}); err == ErrNotAcceptable {
+			// keep the original status
+			c.Res.Status = status
+			c.Res.Plain(http.StatusText(status))
+		}
 	}
 
 	return NEXT_CONTINUE
@@ -22,6 +22,7 @@ func (f *Flashing) Name() string {
 // @impl Middleware
 func (f *Flashing) Handle(c *Context) int {
 	c.Flash = &Flash{c.Sess}
+	c.Set(CTX_FLASH_KEY, c.Flash)
 	return NEXT_CONTINUE
 }
 
@@ -0,0 +1,120 @@
+package uweb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//
+// Host bound router, the pattern is labels split by '.',
+// a label may be a param, eg. ":tenant.example.com" or
+// ":tenant<[a-z]+>.example.com". Constraint can not contain '.'.
+//
+type hostRouter struct {
+	pattern string
+	labels  []*RNode // static or param labels
+	params  int      // count of param labels
+	router  *Router
+}
+
+// Hosts shared by root router and its groups
+type hostTable struct {
+	list []*hostRouter // literal hosts first, then less params first
+}
+
+var (
+	ErrHostNested = errors.New("Router: host router can not have hosts")
+)
+
+// Parse host pattern
+func newHostRouter(pattern string, r *Router) (*hostRouter, error) {
+	h := &hostRouter{
+		pattern: pattern,
+		router:  r,
+	}
+	for _, label := range strings.Split(pattern, ".") {
+		n, err := newRNode(label)
+		if err != nil {
+			return nil, fmt.Errorf("Router: bad host %q, %s", pattern, err)
+		}
+		switch n.kind {
+		case nodeStatic:
+			n.path = strings.ToLower(n.path)
+		case nodeParam:
+			h.params++
+		default:
+			return nil, fmt.Errorf("Router: bad host %q, no catch-all", pattern)
+		}
+		h.labels = append(h.labels, n)
+	}
+	return h, nil
+}
+
+// Match host without port, and add params to ps
+func (h *hostRouter) match(host string, ps Params) (Params, bool) {
+	if strings.Count(host, ".")+1 != len(h.labels) {
+		return ps, false
+	}
+	for _, n := range h.labels {
+		label := host
+		if i := strings.IndexByte(host, '.'); i != -1 {
+			label, host = host[:i], host[i+1:]
+		}
+		switch n.kind {
+		case nodeStatic:
+			if !strings.EqualFold(n.path, label) {
+				return ps, false
+			}
+		case nodeParam:
+			if n.check != nil && !n.check(label) {
+				return ps, false
+			}
+			if ps == nil {
+				ps = make(Params, h.params)
+			}
+			ps[n.name] = label
+		}
+	}
+	return ps, true
+}
+
+// Add host router by priority
+func (t *hostTable) add(h *hostRouter) error {
+	i := len(t.list)
+	for i > 0 && t.list[i-1].params > h.params {
+		i--
+	}
+	for _, o := range t.list {
+		if o.pattern == h.pattern {
+			return fmt.Errorf("Router: dup host %q", h.pattern)
+		}
+	}
+	t.list = append(t.list, nil)
+	copy(t.list[i+1:], t.list[i:])
+	t.list[i] = h
+	return nil
+}
+
+// Find router by request host, host params is nil if none
+func (t *hostTable) match(host string) (*Router, Params) {
+	if t == nil || len(t.list) == 0 {
+		return nil, nil
+	}
+	host = stripPort(host)
+	for _, h := range t.list {
+		if ps, ok := h.match(host, nil); ok {
+			return h.router, ps
+		}
+	}
+	return nil, nil
+}
+
+// "example.com:8080" => "example.com", "[::1]:80" => "::1"
+func stripPort(host string) string {
+	i := strings.LastIndexByte(host, ':')
+	if i == -1 || strings.LastIndexByte(host, ']') > i {
+		return strings.Trim(host, "[]")
+	}
+	return strings.Trim(host[:i], "[]")
+}
@@ -100,6 +100,7 @@ func (i *I18n) Handle(c *Context) int {
 
 	// c
 	c.Locale = &Locale{code: code, i18n: i}
+	c.Set(CTX_LOCALE_KEY, c.Locale)
 	return NEXT_CONTINUE
 }
 
@@ -0,0 +1,95 @@
+package uweb
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+var (
+	ErrBodyTooLarge = &StatusError{413, errors.New("BodyLimit: request body too large")}
+)
+
+//
+// Create body limit middleware, n is max bytes of request body.
+// Use it on app for default, and on group or route to override, eg.
+// uweb.Post("/upload", Upload).Use(uweb.MdBodyLimit(100 << 20)),
+// the last one wins since it wraps the original body.
+//
+func MdBodyLimit(n int64) Middleware {
+	if n <= 0 {
+		panic("BodyLimit: n <= 0")
+	}
+	return &BodyLimit{
+		n: n,
+	}
+}
+
+//
+// BodyLimit wraps body with http.MaxBytesReader, responds
+// 413 if handler reads more than limit
+//
+type BodyLimit struct {
+	n int64
+}
+
+func (b *BodyLimit) Name() string {
+	return "bodylimit"
+}
+
+// @impl Middleware
+func (b *BodyLimit) Handle(c *Context) int {
+	if c.Req.Body == nil || c.Req.Body == http.NoBody {
+		return NEXT_CONTINUE
+	}
+
+	// wrap the original, so an inner limit overrides an outer one
+	if c.Req.body == nil {
+		c.Req.body = c.Req.Body
+	}
+	lb := &limitBody{
+		ReadCloser: http.MaxBytesReader(c.Res, c.Req.body, b.n),
+		n:          b.n,
+		length:     c.Req.ContentLength,
+	}
+	c.Req.Body = lb
+
+	// next
+	c.Next()
+
+	// hit the limit, whatever handler returns
+	if lb.hit {
+		c.Res.Status = ErrBodyTooLarge.Status
+		c.Res.Err = ErrBodyTooLarge
+		c.Res.discard()
+		return NEXT_BREAK
+	}
+	return NEXT_CONTINUE
+}
+
+//
+// Limited body, remembers if limit is hit
+//
+type limitBody struct {
+	io.ReadCloser
+	n      int64
+	length int64 // Content-Length, -1 if unknown
+	read   bool
+	hit    bool
+}
+
+func (lb *limitBody) Read(p []byte) (int, error) {
+	// too large by Content-Length, fail without reading
+	if !lb.read && lb.length > lb.n {
+		lb.hit = true
+		return 0, &http.MaxBytesError{Limit: lb.n}
+	}
+	lb.read = true
+
+	n, err := lb.ReadCloser.Read(p)
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		lb.hit = true
+	}
+	return n, err
+}
@@ -0,0 +1,102 @@
+package uweb
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBodyLimit(t *testing.T) {
+	read := func(c *Context) (int, error) {
+		b, err := io.ReadAll(c.Req.Body)
+		if err != nil {
+			return 500, err
+		}
+		return 200, c.Res.Plain(string(b))
+	}
+	r := NewRouter()
+	r.Post("/small", read)
+	r.Post("/large", read).Use(MdBodyLimit(20))
+	app := NewApp()
+	app.Use(MdBodyLimit(10))
+	app.Use(r)
+
+	tests := []struct {
+		url    string
+		size   int
+		length bool // send Content-Length
+		code   int
+	}{
+		{"/small", 10, true, 200},
+		{"/small", 11, true, 413},
+		{"/small", 11, false, 413},
+		{"/large", 20, false, 200},
+		{"/large", 21, true, 413},
+	}
+	for _, tt := range tests {
+		var body io.Reader = strings.NewReader(strings.Repeat("a", tt.size))
+		if !tt.length {
+			body = io.MultiReader(body) // hide length
+		}
+		req, _ := http.NewRequest("POST", "http://localhost"+tt.url, body)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s %d: got %d, want %d", tt.url, tt.size, w.Code, tt.code)
+		}
+	}
+}
+
+func TestFormFileSave(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 100))
+	var dir string
+	type file struct {
+		name string
+		data []byte
+	}
+	upload := func(opts *UploadOpts, files ...file) (string, error) {
+		buf := new(bytes.Buffer)
+		mw := multipart.NewWriter(buf)
+		for _, f := range files {
+			fw, _ := mw.CreateFormFile("file", f.name)
+			fw.Write(f.data)
+		}
+		mw.Close()
+		req, _ := http.NewRequest("POST", "http://localhost/", buf)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return NewRequest(req).FormFileSave("file", dir, opts)
+	}
+
+	// sniffed, not by name
+	dir = t.TempDir()
+	opts := &UploadOpts{MaxSize: 200, MaxFiles: 2, Exts: []string{".png", ".jpg"}, Types: []string{"image/*"}}
+	if _, err := upload(opts, file{"a.png", png}); err != nil {
+		t.Error("png:", err)
+	}
+	if _, err := upload(opts, file{"a.png", []byte("<html>")}); err != ErrUploadType {
+		t.Error("fake png:", err)
+	}
+	if _, err := upload(opts, file{"a.gif", png}); err != ErrUploadType {
+		t.Error("ext:", err)
+	}
+	if _, err := upload(opts, file{"a.png", append(png, make([]byte, 200)...)}); err != ErrUploadTooLarge {
+		t.Error("size:", err)
+	}
+	if _, err := upload(opts, file{"a.png", png}, file{"b.png", png}, file{"c.png", png}); err != ErrUploadTooMany {
+		t.Error("count:", err)
+	}
+
+	// one bad file removes the saved ones
+	dir = t.TempDir()
+	if _, err := upload(opts, file{"a.png", png}, file{"b.jpg", []byte("text")}); err != ErrUploadType {
+		t.Error("mixed:", err)
+	}
+	if fs, _ := os.ReadDir(dir); len(fs) != 0 {
+		t.Errorf("%d files left", len(fs))
+	}
+}
@@ -70,19 +70,23 @@ func (lg *Logger) Handle(c *Context) int {
 
 	start := time.Now()
 	c.Next()
-	stop := time.Now()
 
-	spend := int64(stop.Sub(start) / time.Millisecond)
-	size := len(c.Res.Body)
-	resBody := "\n"
-	if lg.level == LOG_LEVEL_2 {
-		dump := "c.Res.Body == null"
-		if size > 0 {
-			dump = string(c.Res.Body)
+	// log after response is written, to get bytes actually
+	// written, also for streams
+	c.Res.OnEnd(func() {
+		spend := int64(time.Since(start) / time.Millisecond)
+		resBody := "\n"
+		if lg.level == LOG_LEVEL_2 {
+			dump := "c.Res.Body == null"
+			if len(c.Res.Body) > 0 {
+				dump = string(c.Res.Body)
+			} else if c.Res.stream != nil || c.Res.file != nil {
+				dump = "(stream)"
+			}
+			resBody = fmt.Sprintf("\n{\n\n%s\n\n}\n", dump)
 		}
-		resBody = fmt.Sprintf("\n{\n\n%s\n\n}\n", dump)
-	}
-	log.Printf("%s %s %s %s %s %d %d(byte) %d(ms) %s", LOG_TAG, c.Req.IP, "<--", c.Req.Method, c.Req.URL.Path, c.Res.Status, size, spend, resBody)
+		log.Printf("%s %s %s %s %s %d %d(byte) %d(ms) %s", LOG_TAG, c.Req.IP, "<--", c.Req.Method, c.Req.URL.Path, c.Res.Status, c.Res.Size(), spend, resBody)
+	})
 
 	return NEXT_CONTINUE
 }
@@ -0,0 +1,208 @@
+package uweb
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// media types for Negotiate
+const (
+	MIME_HTML  = "text/html"
+	MIME_JSON  = "application/json"
+	MIME_XML   = "application/xml"
+	MIME_PLAIN = "text/plain"
+)
+
+var (
+	ErrNotAcceptable = &StatusError{406, errors.New("Negotiate: not acceptable")}
+)
+
+//
+// Options of Negotiate
+//
+type NegotiateOpts struct {
+	// template name for c.Render.Html, text/html is
+	// offered only if it's set and MdRender is used
+	Html string
+
+	// offered types in server preference order, default
+	// MIME_HTML, MIME_JSON, MIME_XML, MIME_PLAIN
+	Offers []string
+}
+
+// Write data in the type client prefers by Accept with q-values, json,
+// html, xml or plain text. If nothing acceptable is offered, status
+// is 406 and ErrNotAcceptable is returned, return it from handler.
+func (res *Response) Negotiate(status int, data interface{}, opts *NegotiateOpts) error {
+	if opts == nil {
+		opts = new(NegotiateOpts)
+	}
+	offers := opts.Offers
+	if len(offers) == 0 {
+		offers = []string{MIME_HTML, MIME_JSON, MIME_XML, MIME_PLAIN}
+	}
+
+	// html only if it can be rendered
+	var render Render
+	if res.c != nil {
+		render = res.c.Render
+	}
+	if len(opts.Html) == 0 || render == nil {
+		offers = withoutOffer(offers, MIME_HTML)
+	}
+
+	// pick
+	accept := ""
+	if res.c != nil && res.c.Req != nil {
+		accept = res.c.Req.Header.Get("Accept")
+	}
+	addVary(res, "Accept")
+	mime := negotiate(accept, offers)
+	if len(mime) == 0 {
+		res.Status = ErrNotAcceptable.Status
+		return ErrNotAcceptable
+	}
+
+	// write
+	res.Status = status
+	switch mime {
+	case MIME_HTML:
+		return render.Html(opts.Html, data)
+	case MIME_JSON:
+		return res.Json(data)
+	case MIME_XML:
+		b, err := xml.Marshal(data)
+		if err != nil {
+			return err
+		}
+		res.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		res.Body = append([]byte(xml.Header), b...)
+		return nil
+	case MIME_PLAIN:
+		switch v := data.(type) {
+		case string:
+			return res.Plain(v)
+		case []byte:
+			return res.Plain(string(v))
+		case error:
+			return res.Plain(v.Error())
+		default:
+			return res.Plain(fmt.Sprint(v))
+		}
+	}
+	return fmt.Errorf("Negotiate: can not write %s", mime)
+}
+
+// one media range of Accept
+type acceptRange struct {
+	typ, sub string
+	q        float64
+}
+
+// parse Accept, invalid ranges are ignored
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		typ, sub, ok := strings.Cut(strings.ToLower(strings.TrimSpace(fields[0])), "/")
+		if !ok || len(typ) == 0 || len(sub) == 0 {
+			continue
+		}
+		r := acceptRange{typ, sub, 1}
+		for _, p := range fields[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "q") {
+				if q, err := strconv.ParseFloat(v, 64); err == nil && q >= 0 && q <= 1 {
+					r.q = q
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// Pick the offer of highest q, by the most specific range matches it,
+// the first offer wins on tie. Empty Accept accepts anything.
+func negotiate(accept string, offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if len(strings.TrimSpace(accept)) == 0 {
+		return offers[0]
+	}
+	ranges := parseAccept(accept)
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		typ, sub, _ := strings.Cut(offer, "/")
+		q, spec := 0.0, -1
+		for _, r := range ranges {
+			s := -1
+			switch {
+			case r.typ == typ && r.sub == sub:
+				s = 2
+			case r.typ == typ && r.sub == "*":
+				s = 1
+			case r.typ == "*" && r.sub == "*":
+				s = 0
+			}
+			if s > spec {
+				q, spec = r.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+func withoutOffer(offers []string, mime string) []string {
+	out := make([]string, 0, len(offers))
+	for _, o := range offers {
+		if o != mime {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// add to Vary if not there
+func addVary(res *Response, field string) {
+	for _, v := range res.Header()["Vary"] {
+		for _, f := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(f), field) {
+				return
+			}
+		}
+	}
+	res.Header().Add("Vary", field)
+}
+
+// Marshal map as <map><item key="k">v</item></map>, keys sorted,
+// since keys may not be valid xml names
+func (m Map) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		item := xml.StartElement{
+			Name: xml.Name{Local: "item"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: k}},
+		}
+		if err := e.EncodeElement(m[k], item); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
@@ -0,0 +1,72 @@
+package uweb
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{MIME_HTML, MIME_JSON, MIME_XML, MIME_PLAIN}
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", MIME_HTML},
+		{"*/*", MIME_HTML},
+		{"application/json", MIME_JSON},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", MIME_HTML},
+		{"application/json;q=0.5, application/xml", MIME_XML},
+		{"text/*", MIME_HTML},
+		{"text/*, text/html;q=0", MIME_PLAIN},
+		{"application/json, */*;q=0.1", MIME_JSON},
+		{"image/png", ""},
+		{"*/*;q=0", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiate(tt.accept, offers); got != tt.want {
+			t.Errorf("negotiate(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestNegotiateErrPage(t *testing.T) {
+	r := NewRouter()
+	r.Get("/user", func(c *Context) (int, error) {
+		return 200, c.Res.Negotiate(200, Map{"name": "lcq"}, &NegotiateOpts{
+			Offers: []string{MIME_JSON, MIME_XML},
+		})
+	})
+	r.Get("/fail", func(c *Context) (int, error) {
+		return 400, errors.New("bad id")
+	})
+	app := NewApp()
+	app.Use(MdErrPage(Map{"home": "/"}))
+	app.Use(r)
+
+	tests := []struct {
+		url, accept string
+		code        int
+		ct, body    string
+	}{
+		{"/user", "application/xml", 200, "application/xml", `<Map><item key="name">lcq</item></Map>`},
+		{"/user", "", 200, "application/json", `"name": "lcq"`},
+		{"/user", "text/html", 406, "text/plain", "Not Acceptable"},
+		{"/fail", "application/json", 400, "application/json", `"error": "bad id"`},
+		{"/none", "application/json", 404, "application/json", `"status": 404`},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "http://localhost"+tt.url, nil)
+		req.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		if w.Code != tt.code || !strings.HasPrefix(w.Header().Get("Content-Type"), tt.ct) || !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("%s %q: got %d %q %q", tt.url, tt.accept, w.Code, w.Header().Get("Content-Type"), w.Body.String())
+		}
+		if w.Header().Get("Vary") != "Accept" {
+			t.Errorf("%s: Vary %q", tt.url, w.Header().Get("Vary"))
+		}
+	}
+}
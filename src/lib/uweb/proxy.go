@@ -0,0 +1,203 @@
+package uweb
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// proxies whose forwarded headers are trusted
+var trustedProxies atomic.Pointer[[]netip.Prefix]
+
+func init() {
+	// nginx on the same host
+	if err := SetTrustedProxies("127.0.0.0/8", "::1/128"); err != nil {
+		panic(err)
+	}
+}
+
+//
+// Set proxies whose Forwarded, X-Forwarded-* and X-Real-IP headers
+// are trusted, CIDRs or single IPs, default is loopback. Call it with
+// nothing for direct deployments, then headers are ignored, eg.
+// uweb.SetTrustedProxies("127.0.0.1", "10.0.0.0/8")
+//
+func SetTrustedProxies(cidrs ...string) error {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, s := range cidrs {
+		var p netip.Prefix
+		var err error
+		if strings.Contains(s, "/") {
+			p, err = netip.ParsePrefix(s)
+		} else {
+			var a netip.Addr
+			a, err = netip.ParseAddr(s)
+			p = netip.PrefixFrom(a, a.BitLen())
+		}
+		if err != nil {
+			return err
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	trustedProxies.Store(&prefixes)
+	return nil
+}
+
+// ip is in trusted proxies
+func isTrustedProxy(ip string) bool {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	a = a.Unmap()
+	for _, p := range *trustedProxies.Load() {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// one hop of forwarded chain, proto and host only by Forwarded
+type forwardedHop struct {
+	ip    string
+	proto string
+	host  string
+}
+
+//
+// Client ip, scheme and host of request. Forwarded headers are used
+// only if the peer is a trusted proxy, then the chain is walked from
+// right to left, skipping trusted hops, the first untrusted one is
+// the client. Forwarded (RFC 7239) wins over X-Forwarded-For, and
+// X-Real-IP is the last choice. Scheme and host are of the client hop
+// of Forwarded, or the last value of X-Forwarded-Proto and -Host.
+//
+func readForwarded(r *http.Request) (ip, scheme, host string) {
+	ip = hostIp(r.RemoteAddr)
+	scheme, host = "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if !isTrustedProxy(ip) {
+		return
+	}
+
+	// chain
+	hops := parseForwarded(r.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			for _, s := range strings.Split(v, ",") {
+				hops = append(hops, forwardedHop{ip: hostIp(strings.TrimSpace(s))})
+			}
+		}
+	}
+	if len(hops) == 0 {
+		if v := hostIp(strings.TrimSpace(r.Header.Get("X-Real-IP"))); len(v) > 0 {
+			hops = append(hops, forwardedHop{ip: v})
+		}
+	}
+
+	// client hop
+	client := forwardedHop{}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if _, err := netip.ParseAddr(hops[i].ip); err != nil {
+			// "unknown" or obfuscated, the proxy at right is all we know
+			client.proto, client.host = hops[i].proto, hops[i].host
+			break
+		}
+		client = hops[i]
+		if !isTrustedProxy(client.ip) {
+			break
+		}
+	}
+	if len(client.ip) > 0 {
+		ip = client.ip
+	}
+
+	// scheme and host
+	if len(client.proto) == 0 {
+		client.proto = lastValue(r.Header.Values("X-Forwarded-Proto"))
+	}
+	if len(client.host) == 0 {
+		client.host = lastValue(r.Header.Values("X-Forwarded-Host"))
+	}
+	if p := strings.ToLower(client.proto); p == "http" || p == "https" {
+		scheme = p
+	}
+	if len(client.host) > 0 && !strings.ContainsAny(client.host, "/\\ @") {
+		host = client.host
+	}
+	return
+}
+
+// parse Forwarded headers, eg.
+// Forwarded: for=192.0.2.60;proto=https, for="[2001:db8::17]:4711"
+func parseForwarded(vals []string) []forwardedHop {
+	var hops []forwardedHop
+	for _, v := range vals {
+		for _, elem := range splitQuoted(v, ',') {
+			if len(strings.TrimSpace(elem)) == 0 {
+				continue
+			}
+			var hop forwardedHop
+			for _, pair := range splitQuoted(elem, ';') {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				val = strings.Trim(val, `"`)
+				switch strings.ToLower(k) {
+				case "for":
+					hop.ip = hostIp(val)
+				case "proto":
+					hop.proto = val
+				case "host":
+					hop.host = val
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// split s by sep outside of quotes
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// ip of "ip", "ip:port", "[ipv6]" or "[ipv6]:port"
+func hostIp(s string) string {
+	if h, _, err := net.SplitHostPort(s); err == nil {
+		return h
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+}
+
+// last value of comma separated headers
+func lastValue(vals []string) string {
+	if len(vals) == 0 {
+		return ""
+	}
+	v := vals[len(vals)-1]
+	if i := strings.LastIndexByte(v, ','); i != -1 {
+		v = v[i+1:]
+	}
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,108 @@
+package uweb
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+)
+
+func TestReadForwarded(t *testing.T) {
+	defer SetTrustedProxies("127.0.0.0/8", "::1/128")
+	if err := SetTrustedProxies("127.0.0.1", "10.0.0.0/8", "::1/128"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("bad cidr")
+	}
+
+	tests := []struct {
+		remote  string
+		headers map[string]string
+		ip      string
+		url     string
+	}{
+		// direct, headers ignored
+		{"1.2.3.4:5000", nil, "1.2.3.4", "http://example.com/a"},
+		{"1.2.3.4:5000", map[string]string{"X-Forwarded-For": "9.9.9.9", "X-Forwarded-Proto": "https"}, "1.2.3.4", "http://example.com/a"},
+		{"[2001:db8::1]:5000", nil, "2001:db8::1", "http://example.com/a"},
+
+		// nginx
+		{"127.0.0.1:5000", nil, "127.0.0.1", "http://example.com/a"},
+		{"127.0.0.1:5000", map[string]string{"X-Real-IP": "1.2.3.4"}, "1.2.3.4", "http://example.com/a"},
+		{"127.0.0.1:5000", map[string]string{
+			"X-Forwarded-For":   "1.2.3.4",
+			"X-Forwarded-Proto": "https",
+			"X-Forwarded-Host":  "www.example.com",
+		}, "1.2.3.4", "https://www.example.com/a"},
+
+		// spoofed left entries are skipped, trusted hops too
+		{"127.0.0.1:5000", map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 10.0.0.2"}, "1.2.3.4", "http://example.com/a"},
+		{"127.0.0.1:5000", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3", "http://example.com/a"},
+		{"127.0.0.1:5000", map[string]string{"X-Forwarded-Proto": "https, http"}, "127.0.0.1", "http://example.com/a"},
+		{"127.0.0.1:5000", map[string]string{"X-Forwarded-Proto": "gopher"}, "127.0.0.1", "http://example.com/a"},
+
+		// rfc 7239
+		{"127.0.0.1:5000", map[string]string{
+			"Forwarded":       `for=6.6.6.6, for="[2001:db8::17]:4711";proto=https;host="a.example.com", for=10.0.0.2;proto=http`,
+			"X-Forwarded-For": "9.9.9.9",
+		}, "2001:db8::17", "https://a.example.com/a"},
+		{"127.0.0.1:5000", map[string]string{"Forwarded": `for=unknown;proto=https, for=10.0.0.2`}, "10.0.0.2", "https://example.com/a"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "http://example.com/a", nil)
+		req.RemoteAddr = tt.remote
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		r := NewRequest(req)
+		if r.IP != tt.ip || r.UrlFor("/a") != tt.url {
+			t.Errorf("%s %v: got %s %s, want %s %s", tt.remote, tt.headers, r.IP, r.UrlFor("/a"), tt.ip, tt.url)
+		}
+	}
+
+	// tls
+	req, _ := http.NewRequest("GET", "https://example.com/a", nil)
+	req.RemoteAddr = "1.2.3.4:5000"
+	req.TLS = &tls.ConnectionState{}
+	if u := NewRequest(req).UrlFor("/a"); u != "https://example.com/a" {
+		t.Error("tls:", u)
+	}
+
+	// none trusted
+	SetTrustedProxies()
+	req, _ = http.NewRequest("GET", "http://example.com/a", nil)
+	req.RemoteAddr = "127.0.0.1:5000"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	if ip := NewRequest(req).IP; ip != "127.0.0.1" {
+		t.Error("none trusted:", ip)
+	}
+}
+
+func TestWSSameOrigin(t *testing.T) {
+	tests := []struct {
+		remote string
+		origin string
+		ok     bool
+	}{
+		{"1.2.3.4:5000", "", true},
+		{"1.2.3.4:5000", "http://backend:8080", true},
+		{"1.2.3.4:5000", "https://www.example.com", false},
+
+		// behind proxy, compared with forwarded host
+		{"127.0.0.1:5000", "https://www.example.com", true},
+		{"127.0.0.1:5000", "http://backend:8080", false},
+		{"127.0.0.1:5000", "https://evil.com", false},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "http://backend:8080/ws", nil)
+		req.RemoteAddr = tt.remote
+		req.Header.Set("X-Forwarded-Host", "www.example.com")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		if len(tt.origin) > 0 {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if ok := wsSameOrigin(NewRequest(req)); ok != tt.ok {
+			t.Errorf("%s %q: got %v", tt.remote, tt.origin, ok)
+		}
+	}
+}
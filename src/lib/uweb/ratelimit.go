@@ -0,0 +1,297 @@
+package uweb
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRateLimited = &StatusError{429, errors.New("RateLimit: too many requests")}
+)
+
+// -----------------------------------------------------------------------------
+// keys
+
+// Limit by client ip
+func RateKeyIP(c *Context) string {
+	return "ip:" + c.Req.IP
+}
+
+// Limit by session id, by ip if no session, needs MdSession before
+func RateKeySession(c *Context) string {
+	if c.Sess != nil {
+		return "sess:" + c.Sess.Id()
+	}
+	return RateKeyIP(c)
+}
+
+// -----------------------------------------------------------------------------
+// middleware
+
+//
+// Options of MdRateLimit
+//
+type RateLimitOpts struct {
+	// key of client, default RateKeyIP, "" to skip limiting
+	Key func(c *Context) string
+
+	// counters, default a memory store of this middleware,
+	// NewCacheRateStore to share limits across instances
+	Store RateStore
+
+	// separates counters of limiters in one store,
+	// default is limit and window, eg. "5/1m0s"
+	Name string
+}
+
+//
+// Create rate limit middleware, limit requests of a client in window,
+// by sliding window, the previous window is weighted by its overlap.
+// Use it on app for default, and on group or route for more, eg.
+//
+//	uweb.Post("/login", Login).Use(uweb.MdRateLimit(5, time.Minute, &uweb.RateLimitOpts{
+//		Name: "login",
+//	}))
+//
+// Rejected requests are counted too, so hammering keeps it blocked.
+//
+func MdRateLimit(limit int, window time.Duration, opts ...*RateLimitOpts) Middleware {
+	if limit <= 0 || window < time.Second {
+		panic("RateLimit: limit <= 0 or window < 1s")
+	}
+	rl := &RateLimit{
+		limit:  limit,
+		window: window,
+		key:    RateKeyIP,
+		name:   fmt.Sprintf("%d/%s", limit, window),
+		now:    time.Now,
+	}
+	if len(opts) > 0 && opts[0] != nil {
+		if opts[0].Key != nil {
+			rl.key = opts[0].Key
+		}
+		rl.store = opts[0].Store
+		if len(opts[0].Name) > 0 {
+			rl.name = opts[0].Name
+		}
+	}
+	if rl.store == nil {
+		rl.store = NewMemRateStore()
+	}
+	return rl
+}
+
+//
+// RateLimit sets RateLimit-* headers, and responds 429 with
+// Retry-After if limit exceeded
+//
+type RateLimit struct {
+	limit  int
+	window time.Duration
+	key    func(c *Context) string
+	store  RateStore
+	name   string
+	now    func() time.Time
+}
+
+func (rl *RateLimit) Name() string {
+	return "ratelimit"
+}
+
+// @impl Middleware
+func (rl *RateLimit) Handle(c *Context) int {
+	key := rl.key(c)
+	if len(key) == 0 {
+		return NEXT_CONTINUE
+	}
+
+	// count, let it go if store fails
+	now := rl.now()
+	curr, prev, err := rl.store.Hit(rl.name+":"+key, rl.window, now)
+	if err != nil {
+		log.Println(LOG_TAG, "RateLimit: store err", err)
+		return NEXT_CONTINUE
+	}
+
+	// sliding window
+	elapsed := time.Duration(now.UnixNano() % int64(rl.window))
+	weight := 1 - float64(elapsed)/float64(rl.window)
+	count := float64(prev)*weight + float64(curr)
+	remaining := rl.limit - int(math.Ceil(count))
+	if remaining < 0 {
+		remaining = 0
+	}
+	h := c.Res.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(rl.limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(rl.window-elapsed)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rl.limit, ceilSeconds(rl.window)))
+	if count <= float64(rl.limit) {
+		return NEXT_CONTINUE
+	}
+
+	// wait until one more hit is under limit
+	limit, w := float64(rl.limit), float64(rl.window)
+	var wait float64
+	if next := float64(curr + 1); next <= limit {
+		// previous window fades out in this one
+		wait = w*(1-(limit-next)/float64(prev)) - float64(elapsed)
+	} else {
+		// this one fades out in the next one
+		wait = float64(rl.window-elapsed) + w*(1-(limit-1)/float64(curr))
+	}
+	h.Set("Retry-After", strconv.Itoa(ceilSeconds(time.Duration(wait))))
+	c.Res.Status = ErrRateLimited.Status
+	c.Res.Err = ErrRateLimited
+	return NEXT_BREAK
+}
+
+// at least 1
+func ceilSeconds(d time.Duration) int {
+	n := int(math.Ceil(d.Seconds()))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// -----------------------------------------------------------------------------
+// stores
+
+//
+// Counters of fixed windows, the window of now is
+// now.UnixNano() / window
+//
+type RateStore interface {
+	// Count a hit of key in window of now, returns counts
+	// of the current window and the previous one
+	Hit(key string, window time.Duration, now time.Time) (curr, prev int64, err error)
+}
+
+//
+// Counters in memory, for one instance
+//
+type MemRateStore struct {
+	mu      sync.Mutex
+	entries map[string]*rateEntry
+	swept   time.Time
+}
+
+type rateEntry struct {
+	idx    int64
+	window time.Duration
+	curr   int64
+	prev   int64
+}
+
+// Create memory rate store
+func NewMemRateStore() *MemRateStore {
+	return &MemRateStore{
+		entries: make(map[string]*rateEntry),
+	}
+}
+
+// @impl RateStore.Hit
+func (s *MemRateStore) Hit(key string, window time.Duration, now time.Time) (int64, int64, error) {
+	idx := now.UnixNano() / int64(window)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+
+	e, ok := s.entries[key]
+	switch {
+	case !ok || e.window != window:
+		e = &rateEntry{idx: idx, window: window}
+		s.entries[key] = e
+	case e.idx == idx-1:
+		e.idx, e.prev, e.curr = idx, e.curr, 0
+	case e.idx < idx-1:
+		e.idx, e.prev, e.curr = idx, 0, 0
+	}
+	e.curr++
+	return e.curr, e.prev, nil
+}
+
+// remove entries out of windows, once a minute
+func (s *MemRateStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < time.Minute {
+		return
+	}
+	s.swept = now
+	for k, e := range s.entries {
+		if now.UnixNano()/int64(e.window) > e.idx+1 {
+			delete(s.entries, k)
+		}
+	}
+}
+
+//
+// Counters in Cache, shared across instances. Counting is atomic
+// if the cache has Incr, as MemCache does, otherwise it's get then
+// set, and concurrent hits may be lost
+//
+type CacheRateStore struct {
+	cache Cache
+}
+
+// cache with atomic increment
+type cacheIncr interface {
+	Incr(key string, expire int) (int64, error)
+}
+
+// Create cache rate store
+func NewCacheRateStore(cache Cache) *CacheRateStore {
+	return &CacheRateStore{cache}
+}
+
+// @impl RateStore.Hit
+func (s *CacheRateStore) Hit(key string, window time.Duration, now time.Time) (int64, int64, error) {
+	idx := now.UnixNano() / int64(window)
+	key = "ratelimit:" + key + ":"
+	currKey, prevKey := key+strconv.FormatInt(idx, 10), key+strconv.FormatInt(idx-1, 10)
+	expire := ceilSeconds(2 * window)
+
+	// current
+	var curr int64
+	if ci, ok := s.cache.(cacheIncr); ok {
+		n, err := ci.Incr(currKey, expire)
+		if err != nil {
+			return 0, 0, err
+		}
+		curr = n
+	} else {
+		n, err := s.count(currKey)
+		if err != nil {
+			return 0, 0, err
+		}
+		curr = n + 1
+		if err := s.cache.Set(currKey, []byte(strconv.FormatInt(curr, 10)), expire); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// previous
+	prev, err := s.count(prevKey)
+	if err != nil {
+		return 0, 0, err
+	}
+	return curr, prev, nil
+}
+
+// 0 if missing
+func (s *CacheRateStore) count(key string) (int64, error) {
+	data, err := s.cache.Get(key)
+	if err == ErrCacheMiss {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
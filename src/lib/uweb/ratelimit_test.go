@@ -0,0 +1,106 @@
+package uweb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// cache in map, without Incr
+type mapCache struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func (m *mapCache) Set(key string, data []byte, expire int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[key] = data
+	return nil
+}
+
+func (m *mapCache) Get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.data[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	return data, nil
+}
+
+func TestRateLimit(t *testing.T) {
+	now := time.Unix(6000, 0) // start of a minute
+	clock := func() time.Time { return now }
+	limiter := func(limit int, opts *RateLimitOpts) Middleware {
+		rl := MdRateLimit(limit, time.Minute, opts).(*RateLimit)
+		rl.now = clock
+		return rl
+	}
+	ok := func(c *Context) (int, error) {
+		return 200, c.Res.Plain("ok")
+	}
+	r := NewRouter()
+	r.Get("/", ok)
+	r.Post("/login", ok).Use(limiter(2, &RateLimitOpts{Name: "login"}))
+	app := NewApp()
+	app.Use(limiter(3, nil))
+	app.Use(r)
+
+	do := func(method, url, ip string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "http://localhost"+url, nil)
+		req.RemoteAddr = ip + ":5000"
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w
+	}
+	check := func(w *httptest.ResponseRecorder, code int, remaining, retry string) {
+		t.Helper()
+		if w.Code != code || w.Header().Get("RateLimit-Remaining") != remaining || w.Header().Get("Retry-After") != retry {
+			t.Errorf("got %d remaining %q retry %q, want %d %q %q", w.Code,
+				w.Header().Get("RateLimit-Remaining"), w.Header().Get("Retry-After"), code, remaining, retry)
+		}
+	}
+
+	// app limit
+	check(do("GET", "/", "1.1.1.1"), 200, "2", "")
+	check(do("GET", "/", "1.1.1.1"), 200, "1", "")
+	w := do("GET", "/", "1.1.1.1")
+	check(w, 200, "0", "")
+	if w.Header().Get("RateLimit-Limit") != "3" || w.Header().Get("RateLimit-Reset") != "60" {
+		t.Error("headers:", w.Header())
+	}
+	check(do("GET", "/", "1.1.1.1"), 429, "0", "90")
+	check(do("GET", "/", "2.2.2.2"), 200, "2", "")
+
+	// route limit is tighter
+	check(do("POST", "/login", "3.3.3.3"), 200, "1", "")
+	check(do("POST", "/login", "3.3.3.3"), 200, "0", "")
+	check(do("POST", "/login", "3.3.3.3"), 429, "0", "100")
+
+	// half of the previous window counts
+	now = now.Add(90 * time.Second)
+	check(do("GET", "/", "1.1.1.1"), 200, "0", "")
+	check(do("GET", "/", "1.1.1.1"), 429, "0", "30")
+
+	// shared by cache
+	now = time.Unix(6000, 0)
+	store := NewCacheRateStore(&mapCache{data: map[string][]byte{}})
+	a := limiter(2, &RateLimitOpts{Store: store, Key: func(c *Context) string { return "k" }})
+	b := limiter(2, &RateLimitOpts{Store: store, Key: func(c *Context) string { return "k" }})
+	codes := []int{}
+	for _, md := range []Middleware{a, b, a} {
+		app := NewApp()
+		app.Use(md)
+		app.Use(r)
+		req, _ := http.NewRequest("GET", "http://localhost/", nil)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+	}
+	if codes[0] != 200 || codes[1] != 200 || codes[2] != 429 {
+		t.Error("cache store:", codes)
+	}
+}
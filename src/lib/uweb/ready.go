@@ -0,0 +1,38 @@
+package uweb
+
+//
+// Readiness middleware, path responds 200 while serving, and 503
+// once Server starts shutdown, for health checks of load balancers
+//
+func MdReady(p string) Middleware {
+	return &ReadyCheck{
+		path: p,
+	}
+}
+
+//
+// Readiness check of path
+//
+type ReadyCheck struct {
+	path string
+}
+
+func (r *ReadyCheck) Name() string {
+	return "ready"
+}
+
+// @impl Middleware
+func (r *ReadyCheck) Handle(c *Context) int {
+	if c.Req.URL.Path != r.path {
+		return NEXT_CONTINUE
+	}
+	c.Res.Header().Set("Cache-Control", "no-store")
+	if !Ready(c.Ctx()) {
+		c.Res.Status = 503
+		c.Res.Body = []byte("shutting down")
+		return NEXT_BREAK
+	}
+	c.Res.Status = 200
+	c.Res.Body = []byte("ready")
+	return NEXT_BREAK
+}
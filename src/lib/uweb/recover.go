@@ -0,0 +1,126 @@
+package uweb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"runtime/debug"
+)
+
+var (
+	ErrPanic = errors.New("Recover: internal server error")
+)
+
+//
+// PanicReporter is called after a panic is recovered,
+// such as sending it to an error tracking service
+//
+type PanicReporter func(c *Context, err interface{}, stack []byte)
+
+//
+// Create recover middleware, use it after MdErrPage and MdLogger,
+// so a panic turns into 500 and goes through the normal error flow.
+//
+// report - optional, nil if no need
+//
+func MdRecover(report PanicReporter) Middleware {
+	return NewRecovery(report)
+}
+
+//
+// Recovery turns panic into 500 response.
+// In DEBUG mode, it renders a page with stack and request dump.
+//
+type Recovery struct {
+	report PanicReporter
+}
+
+// Create recovery
+func NewRecovery(report PanicReporter) *Recovery {
+	return &Recovery{
+		report: report,
+	}
+}
+
+func (rc *Recovery) Name() string {
+	return "recover"
+}
+
+// @impl Middleware
+func (rc *Recovery) Handle(c *Context) (ret int) {
+	// the chain may panic in route group, restore to ours
+	mws := c.mws
+	defer func() {
+		err := recover()
+		if err == nil {
+			return
+		}
+		// let http server abort the response
+		if err == http.ErrAbortHandler {
+			panic(err)
+		}
+		c.mws, c.cursor = mws, len(mws)
+		rc.recover(c, err)
+		ret = NEXT_BREAK
+	}()
+
+	c.Next()
+	return NEXT_CONTINUE
+}
+
+// log, report and set response
+func (rc *Recovery) recover(c *Context, err interface{}) {
+	stack := debug.Stack()
+	log.Printf("%s Recover: panic %v\n%s", LOG_TAG, err, stack)
+	if rc.report != nil {
+		rc.report(c, err, stack)
+	}
+
+	// discard half done response
+	c.Res.Status = 500
+	c.Res.discard()
+	if !DEBUG {
+		c.Res.Err = ErrPanic
+		return
+	}
+
+	// debug page, error pages should not override it
+	buf := new(bytes.Buffer)
+	dump, _ := httputil.DumpRequest(c.Req.Request, false)
+	if e := debugTpl.Execute(buf, Map{
+		"error":   fmt.Sprint(err),
+		"stack":   string(stack),
+		"request": string(dump),
+	}); e != nil {
+		c.Res.Err = ErrPanic
+		return
+	}
+	c.Res.Err = nil
+	c.Res.Html(buf.Bytes())
+	c.Res.final = true
+}
+
+var debugTpl = template.Must(template.New("debug").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>500 - panic</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+h1 { color: #c00; }
+pre { background: #f4f4f4; padding: 1em; overflow: auto; }
+</style>
+</head>
+<body>
+<h1>panic: {{.error}}</h1>
+<h2>Stack</h2>
+<pre>{{.stack}}</pre>
+<h2>Request</h2>
+<pre>{{.request}}</pre>
+</body>
+</html>
+`))
@@ -0,0 +1,68 @@
+package uweb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// middleware for test, count post Next runs
+type testCounter struct {
+	n int
+}
+
+func (tc *testCounter) Name() string {
+	return "counter"
+}
+
+func (tc *testCounter) Handle(c *Context) int {
+	c.Next()
+	tc.n++
+	return NEXT_CONTINUE
+}
+
+func TestRecover(t *testing.T) {
+	var reported interface{}
+	r := NewRouter()
+	r.Group("/g", new(testCounter)).Get("/panic", func(c *Context) (int, error) {
+		panic("boom")
+	})
+	r.Get("/ok", testHandler(200))
+
+	after := new(testCounter)
+	app := NewApp()
+	app.Use(MdRecover(func(c *Context, err interface{}, stack []byte) {
+		reported = err
+	}))
+	app.Use(r)
+	app.Use(after) // should not run after panic
+
+	for _, debug := range []bool{false, true} {
+		DEBUG = debug
+		req, _ := http.NewRequest("GET", "http://localhost/g/panic", nil)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		if w.Code != 500 {
+			t.Errorf("debug %v: got %d, want 500", debug, w.Code)
+		}
+		if reported != "boom" {
+			t.Errorf("debug %v: reported %v", debug, reported)
+		}
+		if debug != strings.Contains(w.Body.String(), "panic: boom") {
+			t.Errorf("debug %v: body %q", debug, w.Body.String())
+		}
+	}
+	DEBUG = true
+	if after.n != 0 {
+		t.Errorf("middleware after panic run %d times", after.n)
+	}
+
+	// context still works after panic
+	req, _ := http.NewRequest("GET", "http://localhost/ok", nil)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	if w.Code != 200 || after.n != 1 {
+		t.Errorf("after panic: got %d, counter %d", w.Code, after.n)
+	}
+}
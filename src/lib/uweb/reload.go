@@ -0,0 +1,186 @@
+package uweb
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Wait for the new process to serve on reload, the old one keeps
+	// serving if it's not ready in time.
+	RELOAD_READY_TIMEOUT = 30 * time.Second
+
+	ErrReloadListener = errors.New("Server: listener can't be passed on reload")
+	ErrReloadTimeout  = errors.New("Server: new process not ready on reload")
+)
+
+const (
+	// first fd passed by systemd or the parent
+	listenFdsStart = 3
+
+	// LISTEN_PID can't be known before fork, so the parent sets its pid
+	envListenPpid = "UWEB_LISTEN_PPID"
+
+	// child writes a byte to this fd when it's serving
+	envReadyFd = "UWEB_READY_FD"
+)
+
+// listeners inherited, taken by Server in order
+var inherited struct {
+	once sync.Once
+	mu   sync.Mutex
+	ls   []net.Listener
+	next int
+	err  error
+}
+
+// InheritedListeners returns listeners passed by systemd socket
+// activation (LISTEN_PID and LISTEN_FDS), or by the old process on
+// reload. Server.ListenAndServe and ListenTLS use them before binding,
+// so pass them to Server.Serve only if not using those.
+func InheritedListeners() ([]net.Listener, error) {
+	inherited.once.Do(func() {
+		n := listenFds()
+		for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+			f := os.NewFile(uintptr(fd), "listener"+strconv.Itoa(fd))
+			l, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				inherited.err = err
+				return
+			}
+			inherited.ls = append(inherited.ls, l)
+		}
+	})
+	return inherited.ls, inherited.err
+}
+
+// number of fds passed to this process, env is unset,
+// so children don't take them
+func listenFds() int {
+	defer func() {
+		for _, k := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", envListenPpid} {
+			os.Unsetenv(k)
+		}
+	}()
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return 0
+	}
+	pid, ppid := os.Getenv("LISTEN_PID"), os.Getenv(envListenPpid)
+	switch {
+	case pid == strconv.Itoa(os.Getpid()):
+	case len(pid) == 0 && ppid == strconv.Itoa(os.Getppid()):
+	default:
+		return 0
+	}
+	return n
+}
+
+// next inherited listener not taken, nil if none
+func takeInherited() (net.Listener, error) {
+	ls, err := InheritedListeners()
+	if err != nil {
+		return nil, err
+	}
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+
+	if inherited.next >= len(ls) {
+		return nil, nil
+	}
+	inherited.next++
+	return ls[inherited.next-1], nil
+}
+
+// tell the old process it's serving, once
+var notifyOnce sync.Once
+
+func notifyReady() {
+	notifyOnce.Do(func() {
+		fd, err := strconv.Atoi(os.Getenv(envReadyFd))
+		os.Unsetenv(envReadyFd)
+		if err != nil {
+			return
+		}
+		f := os.NewFile(uintptr(fd), "ready")
+		f.Write([]byte{1})
+		f.Close()
+	})
+}
+
+// fd of listener can be passed
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Start the new binary with the listener, and wait it ready.
+// The old one drains after this, as on SIGTERM.
+func (srv *Server) reload() error {
+	fl, ok := srv.listener.(filer)
+	if !ok {
+		return ErrReloadListener
+	}
+	f, err := fl.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// same binary, args, and env, but the listener
+	exe, err := os.Executable()
+	if err != nil {
+		w.Close()
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	for _, kv := range os.Environ() {
+		k, _, _ := strings.Cut(kv, "=")
+		if k != "LISTEN_PID" && k != "LISTEN_FDS" && k != "LISTEN_FDNAMES" && k != envListenPpid && k != envReadyFd {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env,
+		"LISTEN_FDS=1",
+		envListenPpid+"="+strconv.Itoa(os.Getpid()),
+		envReadyFd+"="+strconv.Itoa(listenFdsStart+1),
+	)
+	cmd.ExtraFiles = []*os.File{f, w}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+	srv.log("reload: new process %d started", cmd.Process.Pid)
+
+	// ready, or EOF if it exits
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-time.After(RELOAD_READY_TIMEOUT):
+		err = ErrReloadTimeout
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package uweb
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// the test binary is the new process on reload
+func TestMain(m *testing.M) {
+	if os.Getenv("UWEB_TEST_RELOAD") == "child" {
+		reloadChild()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// serve on the inherited listener until /stop
+func reloadChild() {
+	time.AfterFunc(10*time.Second, func() { os.Exit(1) })
+	var srv *Server
+	srv = &Server{
+		Timeout:          time.Second,
+		NoSignalHandling: true,
+		Server: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/stop" {
+				go srv.Stop(time.Second)
+			}
+			io.WriteString(w, "child")
+		})},
+	}
+	if ls, _ := InheritedListeners(); len(ls) != 1 {
+		os.Exit(1)
+	}
+	if err := srv.ListenAndServe(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func TestServerReload(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no reload on windows")
+	}
+	t.Setenv("UWEB_TEST_RELOAD", "child")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + l.Addr().String()
+	srv := &Server{
+		Timeout:          time.Second,
+		NoSignalHandling: true,
+		Server: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "parent")
+		})},
+	}
+	served := make(chan error)
+	go func() { served <- srv.Serve(l) }()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	get := func(p string) string {
+		res, err := client.Get(url + p)
+		if err != nil {
+			return err.Error()
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		return string(b)
+	}
+	if body := get("/"); body != "parent" {
+		t.Fatal(body)
+	}
+
+	// old one stops after the new one serves
+	srv.Reload()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("not reloaded")
+	}
+	if body := get("/"); body != "child" {
+		t.Error("after reload:", body)
+	}
+	get("/stop")
+}
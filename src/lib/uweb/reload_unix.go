@@ -0,0 +1,11 @@
+//go:build !windows
+
+package uweb
+
+import (
+	"os"
+	"syscall"
+)
+
+// signals to reload binary
+var reloadSignals = []os.Signal{syscall.SIGHUP, syscall.SIGUSR2}
@@ -0,0 +1,8 @@
+package uweb
+
+import (
+	"os"
+)
+
+// no reload on windows, fds can't be passed
+var reloadSignals []os.Signal
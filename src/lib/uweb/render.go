@@ -131,6 +131,7 @@ func (t *Template) Name() string {
 // @impl Midelleware
 func (t *Template) Handle(c *Context) int {
 	c.Render = &tplRender{c, t}
+	c.Set(CTX_RENDER_KEY, c.Render)
 	return NEXT_CONTINUE
 }
 
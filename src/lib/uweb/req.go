@@ -1,6 +1,8 @@
 package uweb
 
 import (
+	"bytes"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -42,35 +44,32 @@ type Request struct {
 	// embbed request for convenient
 	*http.Request
 
-	// client ip
+	// client ip, see SetTrustedProxies
 	IP string
 
+	// "http" or "https" the client requested, and host of it,
+	// by forwarded headers of trusted proxies
+	Scheme string
+	Host   string
+
 	// url pattern params, Router middleware will set it
 	Params Params
 
 	// should work with pjax middleware
 	Pjax bool
+
+	// router matched this request, for reverse url
+	router *Router
+
+	// body before MdBodyLimit wraps it
+	body io.ReadCloser
 }
 
 // Create request
 func NewRequest(req *http.Request) *Request {
-	return &Request{req, readIp(req), nil, false}
-}
-
-// parse real ip if possible
-// may use nginx to set header fields
-func readIp(r *http.Request) string {
-	if v := r.Header.Get("X-Forwarded-For"); v != "" {
-		i := strings.Index(v, ", ")
-		if i == -1 {
-			i = len(v)
-		}
-		return v[:i]
-	}
-	if v := r.Header.Get("X-Real-IP"); v != "" {
-		return v
-	}
-	return ""
+	r := &Request{Request: req}
+	r.IP, r.Scheme, r.Host = readForwarded(req)
+	return r
 }
 
 // -----------------------------------------------------------------------------
@@ -78,11 +77,7 @@ func readIp(r *http.Request) string {
 
 // get full url for p
 func (r *Request) UrlFor(p string) string {
-	scheme := "http://"
-    if r.TLS != nil {
-        scheme = "https://"
-    }
-	return fmt.Sprintf("%s%s%s", scheme, r.Host, p)
+	return fmt.Sprintf("%s://%s%s", r.Scheme, r.Host, p)
 }
 
 // get current full url
@@ -90,6 +85,20 @@ func (r *Request) UrlCurr() string {
 	return r.UrlFor(r.RequestURI)
 }
 
+// get full url of named route, eg.
+// c.Req.UrlForName("user.show", "id", "42")
+func (r *Request) UrlForName(name string, pairs ...string) (string, error) {
+	router := r.router
+	if router == nil {
+		router = defaultRouter
+	}
+	p, err := router.URL(name, pairs...)
+	if err != nil {
+		return "", err
+	}
+	return r.UrlFor(p), nil
+}
+
 // -----------------------------------------------------------------------------
 // form utils
 
@@ -156,10 +165,68 @@ func (r *Request) FormInt64Array(k string) ([]int64, error) {
 	return irr, nil
 }
 
+//
+// Options of FormFileSave, zero values for no limit
+//
+type UploadOpts struct {
+	// max bytes of each file
+	MaxSize int64
+
+	// max number of files
+	MaxFiles int
+
+	// allowed extensions, eg. ".jpg", case insensitive
+	Exts []string
+
+	// allowed types sniffed from content, not from the filename,
+	// eg. "image/jpeg" or "image/*"
+	Types []string
+}
+
+var (
+	ErrUploadTooLarge = &StatusError{413, errors.New("Upload: file too large")}
+	ErrUploadTooMany  = &StatusError{413, errors.New("Upload: too many files")}
+	ErrUploadType     = &StatusError{415, errors.New("Upload: file type not allowed")}
+)
+
+// check ext and sniffed type of file head
+func (o *UploadOpts) allow(filename string, head []byte) bool {
+	if len(o.Exts) > 0 {
+		ext := strings.ToLower(path.Ext(filename))
+		ok := false
+		for _, e := range o.Exts {
+			if strings.ToLower(e) == ext {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(o.Types) > 0 {
+		ct, _, _ := strings.Cut(http.DetectContentType(head), ";")
+		for _, t := range o.Types {
+			if t == ct || (strings.HasSuffix(t, "/*") && strings.HasPrefix(ct, t[:len(t)-1])) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
 //
 // 示例：c.Req.FormFileSave("fire_cert_file", etc.Path("/data/upload"))
 //
-func (r *Request) FormFileSave(name, dstDir string) (string, error) {
+// opts is optional, if any file fails, files saved are removed
+//
+func (r *Request) FormFileSave(name, dstDir string, opts ...*UploadOpts) (string, error) {
+	opt := new(UploadOpts)
+	if len(opts) > 0 && opts[0] != nil {
+		opt = opts[0]
+	}
+
 	// 主要为了调用ParseMulitipartForm，记得先关闭这个src
 	if src, _, err := r.FormFile(name); err != nil {
 		if err == http.ErrMissingFile {
@@ -174,12 +241,18 @@ func (r *Request) FormFileSave(name, dstDir string) (string, error) {
 	// h5可以上传多个文件了，我们把所有文件名用逗号拼接返回:
 	// aaa.jpg,bbb.jpg
 	//
-	dstAry := []string{}
 	fhs := r.MultipartForm.File[name]
+	if opt.MaxFiles > 0 && len(fhs) > opt.MaxFiles {
+		return "", ErrUploadTooMany
+	}
+	dstAry := []string{}
 	for i, _ := range fhs {
 		dstName, err := func(i int) (string, error) {
 			// f
 			f := fhs[i]
+			if opt.MaxSize > 0 && f.Size > opt.MaxSize {
+				return "", ErrUploadTooLarge
+			}
 
 			// src
 			src, err := f.Open()
@@ -188,16 +261,39 @@ func (r *Request) FormFileSave(name, dstDir string) (string, error) {
 			}
 			defer src.Close()
 
+			// sniff
+			head := make([]byte, 512)
+			n, err := io.ReadFull(src, head)
+			if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+				return "", err
+			}
+			head = head[:n]
+			if !opt.allow(f.Filename, head) {
+				return "", ErrUploadType
+			}
+
 			// dst
 			dstName := uuid.New() + path.Ext(f.Filename)
-			dst, err := os.Create(path.Join(dstDir, dstName))
+			dstPath := path.Join(dstDir, dstName)
+			dst, err := os.Create(dstPath)
 			if err != nil {
 				return "", err
 			}
 			defer dst.Close()
 
-			// copy
-			if _, err := io.Copy(dst, src); err != nil {
+			// copy, size is checked again since header may lie
+			var rd io.Reader = io.MultiReader(bytes.NewReader(head), src)
+			if opt.MaxSize > 0 {
+				rd = io.LimitReader(rd, opt.MaxSize+1)
+			}
+			written, err := io.Copy(dst, rd)
+			if err == nil && opt.MaxSize > 0 && written > opt.MaxSize {
+				err = ErrUploadTooLarge
+			}
+			if err != nil {
+				// remove partial file
+				dst.Close()
+				os.Remove(dstPath)
 				return "", err
 			}
 
@@ -206,10 +302,14 @@ func (r *Request) FormFileSave(name, dstDir string) (string, error) {
 		}(i)
 
 		if err != nil {
+			// remove files saved
+			for _, n := range dstAry {
+				os.Remove(path.Join(dstDir, n))
+			}
 			return "", err
 		}
 		dstAry = append(dstAry, dstName)
 	}
 
 	return strings.Join(dstAry, ","), nil
-}
\ No newline at end of file
+}
@@ -1,14 +1,112 @@
 package uweb
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
+	"runtime/debug"
+	"strconv"
 	"strings"
 )
 
+var (
+	ErrSendDir     = errors.New("Response: can not send a directory")
+	ErrNotHijacker = errors.New("Response: writer is not a http.Hijacker")
+)
+
+//
+// Bottom writer of response, counts bytes actually
+// written to client, after compressing if any
+//
+type countWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *countWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *countWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.n += int64(n)
+	return n, err
+}
+
+// keeps sendfile of net/http for SendFile
+// @impl io.ReaderFrom
+func (w *countWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(w.ResponseWriter, r)
+	}
+	w.n += n
+	return n, err
+}
+
+// @impl http.Flusher
+func (w *countWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// @impl http.Hijacker
+func (w *countWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		conn, rw, err := h.Hijack()
+		if err == nil && w.status == 0 {
+			// switching protocols, End should not write
+			w.status = http.StatusSwitchingProtocols
+		}
+		return conn, rw, err
+	}
+	return nil, nil, ErrNotHijacker
+}
+
+// for http.ResponseController
+func (w *countWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+//
+// Writer passed to Stream, flushes after each Write,
+// wrap it with bufio.Writer if writes are small
+//
+type streamWriter struct {
+	res *Response
+}
+
+func (w streamWriter) Write(data []byte) (int, error) {
+	n, err := w.res.Write(data)
+	if err != nil {
+		return n, err
+	}
+	w.res.Flush()
+	return n, nil
+}
+
 //
 // Http response
 //
@@ -20,21 +118,60 @@ type Response struct {
 	Body   []byte
 
 	Close func()
+
+	// body is final, error pages should not override it
+	final bool
+
+	// instead of Body, see Stream and SendFile
+	stream func(w io.Writer) error
+	file   *os.File
+	fi     os.FileInfo
+
+	// bottom writer and callbacks after end
+	base *countWriter
+	ends []func()
+
+	// owner, for Negotiate to get Accept and Render
+	c *Context
 }
 
 // Create response with response
 func NewResponse(w http.ResponseWriter) *Response {
-	return &Response{w, 0, nil, nil, nil}
+	cw := &countWriter{ResponseWriter: w}
+	return &Response{ResponseWriter: cw, base: cw}
 }
 
 // Send status and body
 func (res *Response) End(req *Request) error {
-	// if error, ignore others
-	if res.Err != nil {
-		http.Error(res, res.Err.Error(), res.Status)
+	if res.file != nil {
+		defer res.file.Close()
+	}
+
+	// written by handler already, eg. EventStream
+	if res.sent() {
+		if res.Close != nil {
+			res.Close()
+		}
 		return nil
 	}
 
+	// validation errors as json, other errors as text, ignore others
+	if res.Err != nil {
+		var ve ValidationErrors
+		if !errors.As(res.Err, &ve) {
+			http.Error(res, res.Err.Error(), res.Status)
+			return nil
+		}
+		res.Err = nil
+		res.discard()
+		res.Json(Map{"error": "validation failed", "errors": ve})
+	}
+
+	// body is written by stream or file
+	if res.stream != nil || res.file != nil {
+		return res.endStream(req)
+	}
+
 	// fix status
 	if res.Status == 0 {
 		switch req.Method {
@@ -64,10 +201,17 @@ func (res *Response) End(req *Request) error {
 		res.Header().Del("Content-Encoding")
 	}
 
-	// write body
-	res.WriteHeader(res.Status)
-	if _, err := res.Write(res.Body); err != nil {
-		return err
+	// write body, HEAD only has headers
+	if req.Method == "HEAD" {
+		if len(res.Body) > 0 && len(res.Header().Get("Content-Encoding")) == 0 {
+			res.Header().Set("Content-Length", strconv.Itoa(len(res.Body)))
+		}
+		res.WriteHeader(res.Status)
+	} else {
+		res.WriteHeader(res.Status)
+		if _, err := res.Write(res.Body); err != nil {
+			return err
+		}
 	}
 
 	// release if needed
@@ -79,6 +223,145 @@ func (res *Response) End(req *Request) error {
 	return nil
 }
 
+// Write stream or file, they may be long, flush as they go
+func (res *Response) endStream(req *Request) error {
+	// release if needed, such as compress footer
+	if res.Close != nil {
+		defer res.Close()
+	}
+
+	// file, ServeContent handles HEAD, Range and If-Modified-Since
+	if res.file != nil {
+		http.ServeContent(res, req.Request, res.fi.Name(), res.fi.ModTime(), res.file)
+		res.Status = res.base.status
+		return nil
+	}
+
+	// stream, length is unknown, so it's chunked
+	if res.Status == 0 {
+		res.Status = http.StatusOK
+	}
+	res.Header().Del("Content-Length")
+	res.WriteHeader(res.Status)
+	if req.Method == "HEAD" {
+		return nil
+	}
+
+	// runs after MdRecover returned, header is sent, let http server
+	// abort the connection so client can tell it's cut off
+	defer func() {
+		err := recover()
+		if err == nil {
+			return
+		}
+		if err != http.ErrAbortHandler {
+			log.Printf("%s Response: stream panic %v\n%s", LOG_TAG, err, debug.Stack())
+		}
+		panic(http.ErrAbortHandler)
+	}()
+	if err := res.stream(streamWriter{res}); err != nil {
+		// header is sent, can only log it
+		log.Println(LOG_TAG, "Response: stream err", err)
+		return err
+	}
+	return nil
+}
+
+// Drop body, stream and file, such as on error
+func (res *Response) discard() {
+	res.Body = nil
+	res.stream = nil
+	if res.file != nil {
+		res.file.Close()
+		res.file, res.fi = nil, nil
+	}
+}
+
+// Header is written, End should not write again
+func (res *Response) sent() bool {
+	return res.base.status != 0
+}
+
+// Run callbacks after response is written
+func (res *Response) finish() {
+	// actual status, also when written by others, eg. MdStatic
+	if res.base.status != 0 {
+		res.Status = res.base.status
+	}
+	for _, f := range res.ends {
+		f()
+	}
+}
+
+// Add callback to run after response is written,
+// such as logging status and size
+func (res *Response) OnEnd(f func()) {
+	res.ends = append(res.ends, f)
+}
+
+// Bytes of body written to client, after compressing if any,
+// only known after response is written, see OnEnd
+func (res *Response) Size() int64 {
+	return res.base.n
+}
+
+// Copy to client, by sendfile if not compressed
+// @impl io.ReaderFrom
+func (res *Response) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := res.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(res.ResponseWriter, r)
+}
+
+// Flush buffered data to client
+// @impl http.Flusher
+func (res *Response) Flush() {
+	if f, ok := res.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// for http.ResponseController
+func (res *Response) Unwrap() http.ResponseWriter {
+	return res.ResponseWriter
+}
+
+// Stream body instead of buffering it in Body. f is called
+// when the response is ended, after all middlewares, and each
+// Write is flushed to client. Set Content-Type before, so it
+// can be compressed. If f fails, the status is already sent.
+func (res *Response) Stream(f func(w io.Writer) error) error {
+	res.stream = f
+	res.Body = nil
+	return nil
+}
+
+// Send file with Range and If-Modified-Since support,
+// Content-Type is by file extension if not set.
+// The error is from opening the file, eg. os.IsNotExist.
+func (res *Response) SendFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return ErrSendDir
+	}
+	if res.file != nil {
+		res.file.Close()
+	}
+	res.file, res.fi = f, fi
+	res.Body = nil
+	return nil
+}
+
 // Plain text
 func (res *Response) Plain(data string) error {
 	w := res
@@ -127,6 +410,11 @@ func (res *Response) Html(body []byte) error {
 // from - c.Req.URL.Path
 // to - new path
 func (res *Response) Redirect(from, to string) error {
+	return res.RedirectCode(from, to, 302)
+}
+
+// Redirect with status code, such as 301, 302, 307, 308
+func (res *Response) RedirectCode(from, to string, code int) error {
 	// copy from http/server.go
 	// Location should be an absolute URI, like
 	if u, err := url.Parse(to); err == nil {
@@ -158,7 +446,7 @@ func (res *Response) Redirect(from, to string) error {
 	// RFC2616 recommends that a short note "SHOULD" be included in the
 	// response because older user agents may not understand 301/307.
 	// Shouldn't send the response for POST or HEAD; that leaves GET.
-	res.Status = 302
+	res.Status = code
 	res.Header().Set("Location", to)
 	res.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	res.Body = []byte("Redirecting to " + to + ".")
@@ -0,0 +1,151 @@
+package uweb
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResponseStream(t *testing.T) {
+	var size int64
+	r := NewRouter()
+	r.Get("/export", func(c *Context) (int, error) {
+		c.Res.Header().Set("Content-Type", "text/csv")
+		c.Res.OnEnd(func() {
+			size = c.Res.Size()
+		})
+		return 200, c.Res.Stream(func(w io.Writer) error {
+			for i := 0; i < 100; i++ {
+				if _, err := fmt.Fprintf(w, "%d,row\n", i); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	app := NewApp()
+	app.Use(MdCompress())
+	app.Use(r)
+
+	req, _ := http.NewRequest("GET", "http://localhost/export", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	if w.Code != 200 || w.Header().Get("Content-Encoding") != "gzip" || !w.Flushed {
+		t.Fatal(w.Code, w.Header(), w.Flushed)
+	}
+	if size != int64(w.Body.Len()) {
+		t.Errorf("size %d, written %d", size, w.Body.Len())
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(gr)
+	if !strings.HasPrefix(string(body), "0,row\n") || !strings.HasSuffix(string(body), "99,row\n") {
+		t.Errorf("body %q", body)
+	}
+}
+
+func TestResponseStreamPanic(t *testing.T) {
+	r := NewRouter()
+	r.Get("/export", func(c *Context) (int, error) {
+		return 200, c.Res.Stream(func(w io.Writer) error {
+			io.WriteString(w, "0,row\n")
+			panic("broken row")
+		})
+	})
+	app := NewApp()
+	app.Use(MdRecover(nil))
+	app.Use(r)
+
+	req, _ := http.NewRequest("GET", "http://localhost/export", nil)
+	w := httptest.NewRecorder()
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Fatal("panic:", err)
+		}
+		if w.Code != 200 || w.Body.String() != "0,row\n" {
+			t.Error(w.Code, w.Body.String())
+		}
+	}()
+	app.ServeHTTP(w, req)
+	t.Fatal("not aborted")
+}
+
+func TestResponseSendFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "a.txt")
+	os.WriteFile(name, []byte("0123456789"), 0644)
+
+	r := NewRouter()
+	r.Get("/file/:name", func(c *Context) (int, error) {
+		if err := c.Res.SendFile(filepath.Join(filepath.Dir(name), c.Req.Params["name"])); err != nil {
+			return 404, err
+		}
+		return 200, nil
+	})
+	app := NewApp()
+	app.Use(MdCompress())
+	app.Use(r)
+
+	tests := []struct {
+		url, rng string
+		code     int
+		body     string
+	}{
+		{"/file/a.txt", "", 200, "0123456789"},
+		{"/file/a.txt", "bytes=2-4", 206, "234"},
+		{"/file/b.txt", "", 404, ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "http://localhost"+tt.url, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		if tt.rng != "" {
+			req.Header.Set("Range", tt.rng)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s %s: got %d, want %d", tt.url, tt.rng, w.Code, tt.code)
+			continue
+		}
+		if tt.code < 300 && w.Body.String() != tt.body {
+			t.Errorf("%s %s: body %q, want %q", tt.url, tt.rng, w.Body.String(), tt.body)
+		}
+	}
+
+	// by ReadFrom of server, sendfile
+	var size int64
+	app = NewApp()
+	app.Use(r)
+	r.Get("/size", func(c *Context) (int, error) {
+		c.Res.OnEnd(func() {
+			size = c.Res.Size()
+		})
+		return 200, c.Res.SendFile(name)
+	})
+	req, _ := http.NewRequest("GET", "http://localhost/size", nil)
+	w := &readFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	app.ServeHTTP(w, req)
+	if w.Body.String() != "0123456789" || w.n != 10 || size != 10 {
+		t.Errorf("ReadFrom: body %q, read %d, size %d", w.Body.String(), w.n, size)
+	}
+}
+
+// counts bytes copied by ReadFrom, as sendfile of net/http
+type readFromRecorder struct {
+	*httptest.ResponseRecorder
+	n int64
+}
+
+func (w *readFromRecorder) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(w.ResponseRecorder, r)
+	w.n += n
+	return n, err
+}
@@ -3,8 +3,15 @@ package uweb
 import (
 	"errors"
 	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"runtime"
+	"sort"
 	"strings"
-	"sync"
+	"text/tabwriter"
 	"log"
 )
 
@@ -22,253 +29,314 @@ var (
 	defaultRouter = NewRouter()
 )
 
+// Print route table of default router
 func DumpRoute() {
-	defaultRouter.posts.root.Dump(" ")
+	PrintRoutes(os.Stdout, Routes())
+}
+
+// All routes of default router
+func Routes() []RouteInfo {
+	return defaultRouter.Routes()
 }
 
 // GET
-func Get(p string, h HttpHandler) {
-	defaultRouter.Get(p, h)
+func Get(p string, h HttpHandler) *Route {
+	return defaultRouter.Get(p, h)
 }
 
 // POST
-func Post(p string, h HttpHandler) {
-	defaultRouter.Post(p, h)
+func Post(p string, h HttpHandler) *Route {
+	return defaultRouter.Post(p, h)
 }
 
 // PATCH
-func Patch(p string, h HttpHandler) {
-	defaultRouter.Patch(p, h)
+func Patch(p string, h HttpHandler) *Route {
+	return defaultRouter.Patch(p, h)
 }
 
 // PUT
-func Put(p string, h HttpHandler) {
-	defaultRouter.Put(p, h)
+func Put(p string, h HttpHandler) *Route {
+	return defaultRouter.Put(p, h)
 }
 
 // DELETE
-func Del(p string, h HttpHandler) {
-	defaultRouter.Del(p, h)
+func Del(p string, h HttpHandler) *Route {
+	return defaultRouter.Del(p, h)
 }
 
 // OPTIONS
-func Opts(p string, h HttpHandler) {
-	defaultRouter.Opts(p, h)
+func Opts(p string, h HttpHandler) *Route {
+	return defaultRouter.Opts(p, h)
 }
 
 // HEAD
-func Head(p string, h HttpHandler) {
-	defaultRouter.Head(p, h)
+func Head(p string, h HttpHandler) *Route {
+	return defaultRouter.Head(p, h)
 }
 
-//
-// Handler is handler for http request
-//
-type HttpHandler func(c *Context) (int, error)
+// Group routes with prefix and middlewares
+func Group(prefix string, mws ...Middleware) *Router {
+	return defaultRouter.Group(prefix, mws...)
+}
 
-//
-// Tree node
-//
-type RNode struct {
-	child   []*RNode    // children
-	height  int         // tree height, for fast match
-	pattern string      // path pattern
-	handler HttpHandler // only last height has h
+// Routes for host, eg. "api.example.com" or ":tenant.example.com"
+func Host(pattern string, mws ...Middleware) *Router {
+	return defaultRouter.Host(pattern, mws...)
 }
 
-// Dump internal status
-func (n *RNode) Dump(indent string) {
-	// dump self
-	if len(indent) == 0 {
-		indent = " "
-	}
-	fmt.Printf("%s pattern:%s, height:%d, handler:%d, child:%d\n", indent+indent, n.pattern, n.height, n.handler, len(n.child))
+// Set trailing slash policy of default router
+func SetSlashPolicy(policy int) {
+	defaultRouter.SetSlashPolicy(policy)
+}
 
-	// dump child
-	for _, c := range n.child {
-		c.Dump(indent + indent)
-	}
+// Set path cleaning of default router
+func SetCleanPath(clean bool) {
+	defaultRouter.SetCleanPath(clean)
 }
 
-// Add child node
-func (n *RNode) Add(ps []string, handler HttpHandler) (int, error) {
-	ps = append([]string{n.pattern}, ps...)
-	if ok, err := n.merge(ps, handler); err != nil {
-		return 0, err
-	} else if ok {
-		n.calc()
-	}
-	return n.height, nil
+// Build path of named route
+func URL(name string, pairs ...string) (string, error) {
+	return defaultRouter.URL(name, pairs...)
 }
 
-var (
-	ErrDupPath = errors.New("RNode: dup path")
-)
+// template helper, eg. [[urlfor "user.show" "id" "42"]]
+func init() {
+	Helper("urlfor", URL)
+}
 
-// Merge path to node
-func (n *RNode) merge(ps []string, handler HttpHandler) (bool, error) {
-	// check ps
-	if len(ps) == 0 {
-		return false, nil
-	}
+//
+// Handler is handler for http request
+//
+type HttpHandler func(c *Context) (int, error)
 
-	// match current node and check dup
-	if n.pattern != ps[0] {
-		return false, nil
-	}
-	if len(ps) == 1 {
-		if n.height == 1 || n.handler != nil {
-			return false, ErrDupPath
-		}
-		n.handler = handler
-		return true, nil
-	}
-
-	// let child merge first
-	ps = ps[1:]
-	merged := false
-	for _, c := range n.child {
-		if ok, err := c.merge(ps, handler); err != nil {
-			return false, err
-		} else if ok {
-			merged = true
-			break
+func (h HttpHandler) Name() string {
+	return "handler"
+}
+
+// Run handler as the last middleware of route
+// @impl Middleware
+func (h HttpHandler) Handle(c *Context) int {
+	// handle
+	status, err := h(c)
+
+	// check status
+	if DEBUG {
+		if c.Res.Status != 0 && c.Res.Status != status {
+			log.Println(LOG_TAG, "Route: status conflict!")
 		}
 	}
+	c.Res.Status = status // always use return status
 
-	// if child not merged
-	if !merged {
-		nodes := make([]*RNode, len(ps))
-		for i, p := range ps {
-			nodes[i] = &RNode{
-				pattern: p,
-			}
-			if len(p) == 0 {
-				nodes[i].Dump("")
-				panic("pattern should not empty")
-			}
-			if i > 0 {
-				parent := nodes[i-1]
-				parent.child = append(parent.child, nodes[i])
-			}
+	// check err, it may carry status
+	if err != nil {
+		var se HttpStatuser
+		if errors.As(err, &se) {
+			c.Res.Status = se.HttpStatus()
 		}
-		nodes[len(nodes)-1].handler = handler // only last node owns handler
-		n.child = append(n.child, nodes[0])
+		c.Res.Err = err
+		return NEXT_BREAK
 	}
 
 	// ok
-	return true, nil
+	return NEXT_CONTINUE
 }
 
-// Calc calcuate height of every node
-func (n *RNode) calc() int {
-	max := 0
-	for _, c := range n.child {
-		h := c.calc()
-		if max < h {
-			max = h
-		}
+//
+// Route is a registered handler with its group middlewares
+//
+type Route struct {
+	Method  string // http method
+	Pattern string // full path pattern, with group prefix
+
+	host    string            // host pattern, empty for any host
+	name    string            // for reverse url, empty if not named
+	names   map[string]*Route // router's named routes
+	slash   bool              // pattern has trailing slash
+	wild    bool              // pattern ends with catch-all
+	handler HttpHandler
+	hname   string       // handler name in Info if handler wraps user's, eg. WS
+	mws     []Middleware // group middlewares and handler itself
+}
+
+// Create route, the handler runs after middlewares
+func newRoute(method, pattern string, h HttpHandler, mws []Middleware) *Route {
+	r := &Route{
+		Method:  method,
+		Pattern: pattern,
+		handler: h,
+	}
+	if len(pattern) > 1 && strings.HasSuffix(pattern, "/") {
+		r.slash = true
+	}
+	if i := strings.LastIndex(pattern, "/*"); i != -1 && !strings.Contains(pattern[i+1:], "/") {
+		r.wild = true
 	}
-	n.height = max + 1
-	return n.height
+	r.mws = make([]Middleware, 0, len(mws)+1)
+	r.mws = append(r.mws, mws...)
+	r.mws = append(r.mws, h)
+	return r
 }
 
-// Match patten with path array, and return matched pattern
-func (n *RNode) Match(ps []string, ms map[string]string) *RNode {
-	// if over tree length, not match
-	s := len(ps)
-	if n.height < s {
-		return nil
+// Name the route for reverse url, eg.
+// uweb.Get("/user/:id", UserShow).Name("user.show")
+func (r *Route) Name(name string) *Route {
+	if r.names == nil {
+		panic("Route: not added to router")
 	}
-	// if no path to match
-	if s == 0 {
-		return nil
+	if _, ok := r.names[name]; ok {
+		panic("Route: dup name " + name)
 	}
+	r.name = name
+	r.names[name] = r
+	return r
+}
 
-	// if pattern match fail, ignore
-	p0 := ps[0]
-	if n.pattern[0:1] != ":" && n.pattern != p0 {
-		return nil
-	}
+// Add route middlewares, they run after group middlewares, eg.
+// uweb.Post("/upload", Upload).Use(uweb.MdBodyLimit(100 << 20))
+func (r *Route) Use(mws ...Middleware) *Route {
+	h := r.mws[len(r.mws)-1]
+	r.mws = append(append(r.mws[:len(r.mws)-1:len(r.mws)-1], mws...), h)
+	return r
+}
 
-	// if current node matched
-	if len(ps) == 1 {
-		if n.pattern[0:1] == ":" {
-			ms[n.pattern[1:]] = p0
-		}
-		return n
+// Build path with params, extra pairs are appended as query
+func (r *Route) URL(pairs ...string) (string, error) {
+	if len(pairs)%2 != 0 {
+		return "", fmt.Errorf("Route: odd params for %q", r.Pattern)
+	}
+	params := make(map[string]string, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		params[pairs[i]] = pairs[i+1]
 	}
 
-	// match child first
-	for _, c := range n.child {
-		if h := c.Match(ps[1:], ms); h != nil {
-			if n.pattern[0:1] == ":" {
-				ms[n.pattern[1:]] = p0
+	// fill params
+	ps := strings.Split(strings.Trim(r.Pattern, "/"), "/")
+	for i, p := range ps {
+		if len(p) == 0 || nodeKind(p) == nodeStatic {
+			continue
+		}
+		n, err := newRNode(p)
+		if err != nil {
+			return "", err
+		}
+		v, ok := params[n.name]
+		if !ok || len(v) == 0 {
+			return "", fmt.Errorf("Route: missing param %q for %q", n.name, r.Pattern)
+		}
+		delete(params, n.name)
+		if n.check != nil && !n.check(v) {
+			return "", fmt.Errorf("Route: param %q not match %q", v, p)
+		}
+		if n.kind == nodeCatchAll {
+			vs := strings.Split(v, "/")
+			for j := range vs {
+				vs[j] = url.PathEscape(vs[j])
 			}
-			return h
+			ps[i] = strings.Join(vs, "/")
+		} else {
+			ps[i] = url.PathEscape(v)
 		}
 	}
+	u := "/" + strings.Join(ps, "/")
+	if r.slash && u != "/" {
+		u += "/"
+	}
 
-	// fail
-	return nil
+	// left as query
+	if len(params) > 0 {
+		q := url.Values{}
+		for k, v := range params {
+			q.Set(k, v)
+		}
+		u += "?" + q.Encode()
+	}
+	return u, nil
 }
 
 //
-// RTree is path router tree, for fast match
+// RouteInfo is for route table introspection
 //
-type RTree struct {
-	mu   sync.Mutex
-	root *RNode
+type RouteInfo struct {
+	Host        string   `json:"host,omitempty"`
+	Method      string   `json:"method"`
+	Pattern     string   `json:"pattern"`
+	Name        string   `json:"name,omitempty"`
+	Handler     string   `json:"handler"`
+	Middlewares []string `json:"middlewares"`
+}
+
+// Get route info
+func (r *Route) Info() RouteInfo {
+	handler := r.hname
+	if len(handler) == 0 {
+		handler = funcName(r.handler)
+	}
+	info := RouteInfo{
+		Host:        r.host,
+		Method:      r.Method,
+		Pattern:     r.Pattern,
+		Name:        r.name,
+		Handler:     handler,
+		Middlewares: make([]string, 0, len(r.mws)-1),
+	}
+	for _, m := range r.mws[:len(r.mws)-1] { // last is handler itself
+		info.Middlewares = append(info.Middlewares, middlewareName(m))
+	}
+	return info
 }
 
-// Create a tree with a root node with patten "/"
-func NewRTree() *RTree {
-	root := &RNode{
-		pattern: "/",
+// get func name, eg. "ctrl.Index"
+func funcName(f interface{}) string {
+	v := reflect.ValueOf(f)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return ""
 	}
-	return &RTree{
-		root: root,
+	if fn := runtime.FuncForPC(v.Pointer()); fn != nil {
+		return fn.Name()
 	}
+	return ""
 }
 
-// convert to path array
-func (rt *RTree) parsePath(p string) []string {
-	if p == "/" {
-		return nil
+// get middleware name, or its type if no name
+func middlewareName(m Middleware) string {
+	if name := m.Name(); len(name) > 0 {
+		return name
 	}
-	return strings.Split(strings.Trim(p, "/"), "/")
+	return fmt.Sprintf("%T", m)
 }
 
-// Add path to tree
-func (rt *RTree) Add(p string, h HttpHandler) error {
-	ps := rt.parsePath(p)
-
-	rt.mu.Lock()
-	defer rt.mu.Unlock()
-
-	if _, err := rt.root.Add(ps, h); err != nil {
-		return err
+// Print route table as text, one route per line
+func PrintRoutes(w io.Writer, routes []RouteInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tMETHOD\tPATTERN\tNAME\tHANDLER\tMIDDLEWARES")
+	for _, r := range routes {
+		host := r.Host
+		if len(host) == 0 {
+			host = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", host, r.Method, r.Pattern, r.Name, r.Handler, strings.Join(r.Middlewares, ","))
 	}
-	return nil
+	return tw.Flush()
 }
 
-// Match path and get handler
-func (rt *RTree) Match(p string) (map[string]string, HttpHandler) {
-	ps := append([]string{"/"}, rt.parsePath(p)...)
-	ms := make(map[string]string)
+//
+// Trailing slash policy of router
+//
+const (
+	// "/a/" and "/a" hit the same route
+	SLASH_LENIENT = 0
 
-	rt.mu.Lock()
-	defer rt.mu.Unlock()
+	// trailing slash should be the same as route pattern, or 404
+	SLASH_STRICT = 1
 
-	if n := rt.root.Match(ps, ms); n != nil {
-		return ms, n.handler
-	}
-
-	return nil, nil
-}
+	// redirect to the form of route pattern,
+	// 301 for GET and HEAD, 308 for others to keep method and body
+	SLASH_REDIRECT = 2
+)
 
 //
-// Router is a restfull path router
+// Router is a restfull path router,
+// groups share method trees with their parent.
 //
 type Router struct {
 	gets   *RTree
@@ -278,6 +346,21 @@ type Router struct {
 	dels   *RTree
 	opts   *RTree
 	heads  *RTree
+
+	// named routes, for reverse url
+	names map[string]*Route
+
+	// path policy, only root router's works
+	slashPolicy int  // SLASH_LENIENT, SLASH_STRICT or SLASH_REDIRECT
+	cleanPath   bool // redirect "//a" or "/a/../b" to clean path
+
+	// host routers, only root router's works
+	hosts *hostTable
+	host  string // host pattern if this is a host router
+
+	// group
+	prefix string       // path prefix, no trailing slash
+	mws    []Middleware // group middlewares, run before handler
 }
 
 // Create default router
@@ -290,9 +373,30 @@ func NewRouter() *Router {
 		dels:   NewRTree(),
 		opts:   NewRTree(),
 		heads:  NewRTree(),
+		names:  make(map[string]*Route),
+		hosts:  new(hostTable),
+
+		slashPolicy: SLASH_LENIENT,
+		cleanPath:   true,
+	}
+}
+
+// Set trailing slash policy, default is SLASH_LENIENT
+func (r *Router) SetSlashPolicy(policy int) {
+	switch policy {
+	case SLASH_LENIENT, SLASH_STRICT, SLASH_REDIRECT:
+		r.slashPolicy = policy
+	default:
+		panic("Router: unknown slash policy")
 	}
 }
 
+// Redirect path with duplicate slashes and dot segments
+// to the clean one, default is true
+func (r *Router) SetCleanPath(clean bool) {
+	r.cleanPath = clean
+}
+
 // get route tree
 func (r *Router) treeByMethod(method string) *RTree {
 	var t *RTree
@@ -315,8 +419,12 @@ func (r *Router) treeByMethod(method string) *RTree {
 	return t
 }
 
+// all methods in Allow header order
+var routeMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
 var (
-	ErrRouteNotFound = errors.New("Router: not found")
+	ErrRouteNotFound    = errors.New("Router: not found")
+	ErrMethodNotAllowed = errors.New("Router: method not allowed")
 )
 
 func (r *Router) Name() string {
@@ -325,46 +433,209 @@ func (r *Router) Name() string {
 
 // Middleware impl
 func (r *Router) Handle(c *Context) int {
+	// dispatch on host, use trees of host router if matched
+	tr, hp := r, Params(nil)
+	if hr, ps := r.hosts.match(c.Req.Host); hr != nil {
+		tr, hp = hr, ps
+	}
+
 	// t
-	t := r.treeByMethod(c.Req.Method)
+	t := tr.treeByMethod(c.Req.Method)
 	if t == nil {
 		c.Res.Status = 501
 		c.Res.Err = errors.New("Router: method not support yet")
 		return NEXT_BREAK
 	}
 	
-	// then match
-	p, h := t.Match(c.Req.URL.Path)
-	if h == nil {
+	// clean path first, avoid duplicate content
+	path := c.Req.URL.Path
+	if r.cleanPath {
+		if cp := cleanPath(path); cp != path {
+			return r.redirect(c, cp)
+		}
+	}
+
+	// then match, HEAD falls back to GET, and Response.End strips body
+	p, rt := t.Match(path)
+	if rt == nil && c.Req.Method == "HEAD" {
+		p, rt = tr.gets.Match(path)
+	}
+	if rt == nil {
+		return tr.notMatch(c, path)
+	}
+
+	// trailing slash, catch-all takes it as part of param
+	if r.slashPolicy != SLASH_LENIENT && path != "/" && !rt.wild {
+		if slash := strings.HasSuffix(path, "/"); slash != rt.slash {
+			if r.slashPolicy == SLASH_STRICT {
+				c.Res.Status = 404
+				c.Res.Err = ErrRouteNotFound
+				return NEXT_BREAK
+			}
+			if slash {
+				return r.redirect(c, strings.TrimRight(path, "/"))
+			}
+			return r.redirect(c, path+"/")
+		}
+	}
+
+	// handle, through group middlewares if any
+	for k, v := range hp {
+		if p == nil {
+			p = make(map[string]string, len(hp))
+		}
+		if _, ok := p[k]; !ok { // path param wins
+			p[k] = v
+		}
+	}
+	c.Req.Params = p
+	c.Req.router = r
+	return c.run(rt.mws)
+}
+
+// Redirect to canonical path, keep query
+func (r *Router) redirect(c *Context, p string) int {
+	code := 301
+	if m := c.Req.Method; m != "GET" && m != "HEAD" {
+		code = 308
+	}
+	to := (&url.URL{Path: p}).EscapedPath()
+	if len(c.Req.URL.RawQuery) > 0 {
+		to += "?" + c.Req.URL.RawQuery
+	}
+	c.Res.RedirectCode(c.Req.URL.Path, to, code)
+	return NEXT_BREAK
+}
+
+// Clean duplicate slashes and dot segments, but keep trailing slash
+func cleanPath(p string) string {
+	if len(p) == 0 || p[0] != '/' {
+		p = "/" + p
+	}
+	cp := path.Clean(p)
+	if cp != "/" && strings.HasSuffix(p, "/") {
+		cp += "/"
+	}
+	return cp
+}
+
+// All routes, ordered by host, method then pattern
+func (r *Router) Routes() []RouteInfo {
+	var infos []RouteInfo
+	for _, m := range routeMethods {
+		var routes []*Route
+		r.treeByMethod(m).load().walk(func(rt *Route) {
+			routes = append(routes, rt)
+		})
+		sort.Slice(routes, func(i, j int) bool {
+			return routes[i].Pattern < routes[j].Pattern
+		})
+		for _, rt := range routes {
+			infos = append(infos, rt.Info())
+		}
+	}
+	if r.hosts != nil {
+		for _, h := range r.hosts.list {
+			infos = append(infos, h.router.Routes()...)
+		}
+	}
+	return infos
+}
+
+// Build path of named route, eg.
+// router.URL("user.show", "id", "42") => "/user/42"
+func (r *Router) URL(name string, pairs ...string) (string, error) {
+	rt, ok := r.names[name]
+	if !ok {
+		return "", fmt.Errorf("Router: no route named %q", name)
+	}
+	return rt.URL(pairs...)
+}
+
+// Path not found in method tree, check others for 405 or OPTIONS
+func (r *Router) notMatch(c *Context, path string) int {
+	allow := r.allowed(path)
+	if len(allow) == 0 {
 		c.Res.Status = 404
 		c.Res.Err = ErrRouteNotFound
 		return NEXT_BREAK
 	}
+	c.Res.Header().Set("Allow", strings.Join(allow, ", "))
 
-	// handle
-	c.Req.Params = p
-	status, err := h(c)
-	
-	// check status
-	if DEBUG {
-		if c.Res.Status != 0 && c.Res.Status != status {
-			log.Println(LOG_TAG, "Route: status conflict!")
+	// answer OPTIONS with registered methods
+	if c.Req.Method == "OPTIONS" {
+		c.Res.Status = 204
+		return NEXT_CONTINUE
+	}
+
+	c.Res.Status = 405
+	c.Res.Err = ErrMethodNotAllowed
+	return NEXT_BREAK
+}
+
+// Get methods which can handle the path
+func (r *Router) allowed(path string) []string {
+	var allow []string
+	for _, m := range routeMethods {
+		if m == "OPTIONS" {
+			break
+		}
+		if _, rt := r.treeByMethod(m).Match(path); rt != nil {
+			allow = append(allow, m)
+		} else if m == "HEAD" {
+			if _, rt := r.gets.Match(path); rt != nil {
+				allow = append(allow, m)
+			}
 		}
 	}
-	c.Res.Status = status // always use return status
-	
-	// check err
+	// OPTIONS is always answered, by handler or automatically
+	if _, rt := r.opts.Match(path); rt != nil || len(allow) > 0 {
+		allow = append(allow, "OPTIONS")
+	}
+	return allow
+}
+
+// Group create a sub router, routes added to it have the prefix,
+// and run through its middlewares and its parent's.
+func (r *Router) Group(prefix string, mws ...Middleware) *Router {
+	if !strings.HasPrefix(prefix, "/") {
+		panic("Router: group prefix should start with /")
+	}
+	g := *r
+	g.prefix = r.prefix + strings.TrimRight(prefix, "/")
+	g.mws = make([]Middleware, 0, len(r.mws)+len(mws))
+	g.mws = append(g.mws, r.mws...)
+	g.mws = append(g.mws, mws...)
+	return &g
+}
+
+// Host create a router bound to host pattern, with its own trees.
+// Request host matched goes to it only, and the host params are added
+// to Request.Params. Add all hosts before serving.
+func (r *Router) Host(pattern string, mws ...Middleware) *Router {
+	if r.hosts == nil {
+		panic(ErrHostNested)
+	}
+	hr := NewRouter()
+	hr.names = r.names
+	hr.hosts = nil
+	hr.host = pattern
+	hr.prefix = r.prefix
+	hr.mws = make([]Middleware, 0, len(r.mws)+len(mws))
+	hr.mws = append(hr.mws, r.mws...)
+	hr.mws = append(hr.mws, mws...)
+	h, err := newHostRouter(pattern, hr)
 	if err != nil {
-		c.Res.Err = err
-		return NEXT_BREAK
-	} 
-	
-	// ok
-	return NEXT_CONTINUE
+		panic(err)
+	}
+	if err := r.hosts.add(h); err != nil {
+		panic(err)
+	}
+	return hr
 }
 
 // add handler to method trees
-func (r *Router) addHandler(method, p string, h HttpHandler) {
+func (r *Router) addHandler(method, p string, h HttpHandler) *Route {
 	// t
 	t := r.treeByMethod(method)
 	if t == nil {
@@ -372,35 +643,40 @@ func (r *Router) addHandler(method, p string, h HttpHandler) {
 	}
 	
 	// add
-	if err := t.Add(p, h); err != nil {
+	p = r.prefix + p
+	rt := newRoute(method, p, h, r.mws)
+	if err := t.Add(p, rt); err != nil {
 		panic(err)
 	}
+	rt.host = r.host
+	rt.names = r.names
+	return rt
 }
 
-func (r *Router) Get(p string, h HttpHandler) {
-	r.addHandler("GET", p, h)
+func (r *Router) Get(p string, h HttpHandler) *Route {
+	return r.addHandler("GET", p, h)
 }
 
-func (r *Router) Post(p string, h HttpHandler) {
-	r.addHandler("POST", p, h)
+func (r *Router) Post(p string, h HttpHandler) *Route {
+	return r.addHandler("POST", p, h)
 }
 
-func (r *Router) Patch(p string, h HttpHandler) {
-	r.addHandler("PATCH", p, h)
+func (r *Router) Patch(p string, h HttpHandler) *Route {
+	return r.addHandler("PATCH", p, h)
 }
 
-func (r *Router) Put(p string, h HttpHandler) {
-	r.addHandler("PUT", p, h)
+func (r *Router) Put(p string, h HttpHandler) *Route {
+	return r.addHandler("PUT", p, h)
 }
 
-func (r *Router) Del(p string, h HttpHandler) {
-	r.addHandler("DELETE", p, h)
+func (r *Router) Del(p string, h HttpHandler) *Route {
+	return r.addHandler("DELETE", p, h)
 }
 
-func (r *Router) Opts(p string, h HttpHandler) {
-	r.addHandler("OPTIONS", p, h)
+func (r *Router) Opts(p string, h HttpHandler) *Route {
+	return r.addHandler("OPTIONS", p, h)
 }
 
-func (r *Router) Head(p string, h HttpHandler) {
-	r.addHandler("HEAD", p, h)
+func (r *Router) Head(p string, h HttpHandler) *Route {
+	return r.addHandler("HEAD", p, h)
 }
@@ -0,0 +1,512 @@
+package uweb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// handler returns its id as status, so we know who matched
+func testHandler(id int) HttpHandler {
+	return func(c *Context) (int, error) {
+		if c != nil {
+			c.Res.Plain("handler")
+		}
+		return id, nil
+	}
+}
+
+func testRoute(id int) *Route {
+	return newRoute("GET", "", testHandler(id), nil)
+}
+
+type routeTest struct {
+	path   string
+	id     int // 0 means not found
+	params map[string]string
+}
+
+func testRTree(t *testing.T, routes []string, tests []routeTest) {
+	rt := NewRTree()
+	for i, p := range routes {
+		if err := rt.Add(p, newRoute("GET", p, testHandler(i+1), nil)); err != nil {
+			t.Fatalf("Add(%q): %s", p, err)
+		}
+	}
+	for _, tt := range tests {
+		ms, r := rt.Match(tt.path)
+		if r == nil {
+			if tt.id != 0 {
+				t.Errorf("Match(%q): not found, want %d", tt.path, tt.id)
+			}
+			continue
+		}
+		if id, _ := r.handler(nil); id != tt.id {
+			t.Errorf("Match(%q): got %d, want %d", tt.path, id, tt.id)
+			continue
+		}
+		if len(ms) != len(tt.params) {
+			t.Errorf("Match(%q): params %v, want %v", tt.path, ms, tt.params)
+			continue
+		}
+		for k, v := range tt.params {
+			if ms[k] != v {
+				t.Errorf("Match(%q): param %s=%q, want %q", tt.path, k, ms[k], v)
+			}
+		}
+	}
+}
+
+func TestRTreeCatchAll(t *testing.T) {
+	routes := []string{
+		"/files/*path",
+		"/files/:name",
+		"/files/readme",
+		"/files/a/b",
+		"/*all",
+	}
+	testRTree(t, routes, []routeTest{
+		{"/files/readme", 3, nil},
+		{"/files/other", 2, map[string]string{"name": "other"}},
+		{"/files/a/b", 4, nil},
+		{"/files/a/c", 1, map[string]string{"path": "a/c"}},
+		{"/files/a", 2, map[string]string{"name": "a"}},
+		{"/files/x/y/z", 1, map[string]string{"path": "x/y/z"}},
+		{"/other/x", 5, map[string]string{"all": "other/x"}},
+		{"/", 0, nil},
+	})
+}
+
+func TestRTreeCatchAllErrors(t *testing.T) {
+	rt := NewRTree()
+	if err := rt.Add("/files/*path/more", testRoute(1)); err != ErrCatchAllPos {
+		t.Errorf("catch-all in middle: got %v", err)
+	}
+	if err := rt.Add("/files/*path", testRoute(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Add("/files/*other", testRoute(2)); err != ErrCatchAllConflict {
+		t.Errorf("two catch-all: got %v", err)
+	}
+	if err := rt.Add("/files/*path", testRoute(3)); err != ErrDupPath {
+		t.Errorf("dup catch-all: got %v", err)
+	}
+}
+
+func TestRTreeConstraint(t *testing.T) {
+	routes := []string{
+		"/user/:name",
+		"/user/:id<int>",
+		"/user/:uid<uuid>",
+		"/post/:slug<[a-z0-9-]+>",
+		"/post/:slug<[a-z0-9-]+>/edit",
+		"/post/*rest",
+	}
+	uid := "f47ac10b-58cc-4372-8567-0e02b2c3d479"
+	testRTree(t, routes, []routeTest{
+		{"/user/42", 2, map[string]string{"id": "42"}},
+		{"/user/" + uid, 3, map[string]string{"uid": uid}},
+		{"/user/bob", 1, map[string]string{"name": "bob"}},
+		{"/post/hello-world", 4, map[string]string{"slug": "hello-world"}},
+		{"/post/hello-world/edit", 5, map[string]string{"slug": "hello-world"}},
+		{"/post/Hello", 6, map[string]string{"rest": "Hello"}},
+		{"/post/Hello/edit", 6, map[string]string{"rest": "Hello/edit"}},
+	})
+}
+
+func TestRTreeConstraintErrors(t *testing.T) {
+	for _, p := range []string{
+		"/user/:id<int",
+		"/user/:<int>",
+		"/user/:id<[a-z>",
+		"/files/*path<int>",
+		"/a//b",
+	} {
+		if err := NewRTree().Add(p, testRoute(1)); err == nil {
+			t.Errorf("Add(%q): expect error", p)
+		}
+	}
+}
+
+// middleware for test, break with status if path has no "ok" query
+type testGuard struct {
+	status int
+}
+
+func (g *testGuard) Name() string {
+	return "guard"
+}
+
+func (g *testGuard) Handle(c *Context) int {
+	if c.Req.FormValue("ok") == "" {
+		c.Res.Status = g.status
+		c.Res.Body = []byte("guard")
+		return NEXT_BREAK
+	}
+	return NEXT_CONTINUE
+}
+
+// serve request with router as the last middleware
+func testServe(r *Router, method, url string) *httptest.ResponseRecorder {
+	app := NewApp()
+	app.Use(r)
+	req, _ := http.NewRequest(method, url, nil)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	return w
+}
+
+func TestRouterGroup(t *testing.T) {
+	r := NewRouter()
+	r.Get("/index", testHandler(200))
+	admin := r.Group("/admin/", &testGuard{401})
+	admin.Get("/", testHandler(201))
+	admin.Get("/users/:id", testHandler(202))
+	super := admin.Group("/super", &testGuard{403})
+	super.Get("/panel", testHandler(203))
+
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"/index", 200},
+		{"/admin", 401},
+		{"/admin?ok=1", 201},
+		{"/admin/users/7", 401},
+		{"/admin/users/7?ok=1", 202},
+		{"/admin/super/panel?ok=1", 203},
+		{"/super/panel?ok=1", 404},
+	}
+	for _, tt := range tests {
+		w := testServe(r, "GET", "http://localhost"+tt.url)
+		if w.Code != tt.status {
+			t.Errorf("GET %s: got %d, want %d", tt.url, w.Code, tt.status)
+		}
+	}
+}
+
+func TestRouterMethods(t *testing.T) {
+	r := NewRouter()
+	r.Get("/users", testHandler(200))
+	r.Post("/users", testHandler(201))
+	r.Del("/users/:id", testHandler(200))
+	r.Opts("/custom", testHandler(200))
+
+	tests := []struct {
+		method string
+		url    string
+		status int
+		allow  string
+	}{
+		{"GET", "/users", 200, ""},
+		{"PUT", "/users", 405, "GET, HEAD, POST, OPTIONS"},
+		{"GET", "/users/1", 405, "DELETE, OPTIONS"},
+		{"OPTIONS", "/users", 204, "GET, HEAD, POST, OPTIONS"},
+		{"OPTIONS", "/custom", 200, ""},
+		{"GET", "/custom", 405, "OPTIONS"},
+		{"OPTIONS", "/none", 404, ""},
+		{"HEAD", "/users", 200, ""},
+	}
+	for _, tt := range tests {
+		w := testServe(r, tt.method, "http://localhost"+tt.url)
+		if w.Code != tt.status {
+			t.Errorf("%s %s: got %d, want %d", tt.method, tt.url, w.Code, tt.status)
+		}
+		if allow := w.Header().Get("Allow"); allow != tt.allow {
+			t.Errorf("%s %s: Allow %q, want %q", tt.method, tt.url, allow, tt.allow)
+		}
+	}
+
+	// HEAD has no body, but the length of GET
+	w := testServe(r, "HEAD", "http://localhost/users")
+	if w.Body.Len() != 0 || w.Header().Get("Content-Length") != "7" {
+		t.Errorf("HEAD: body %q, Content-Length %q", w.Body.String(), w.Header().Get("Content-Length"))
+	}
+}
+
+func TestRouterURL(t *testing.T) {
+	r := NewRouter()
+	r.Get("/", testHandler(200)).Name("home")
+	r.Get("/user/:id<int>", testHandler(200)).Name("user.show")
+	r.Group("/admin").Get("/files/*path", testHandler(200)).Name("admin.files")
+
+	tests := []struct {
+		name  string
+		pairs []string
+		url   string
+	}{
+		{"home", nil, "/"},
+		{"user.show", []string{"id", "42"}, "/user/42"},
+		{"user.show", []string{"id", "42", "tab", "a b"}, "/user/42?tab=a+b"},
+		{"admin.files", []string{"path", "a b/c.txt"}, "/admin/files/a%20b/c.txt"},
+	}
+	for _, tt := range tests {
+		u, err := r.URL(tt.name, tt.pairs...)
+		if err != nil || u != tt.url {
+			t.Errorf("URL(%q, %v): got %q %v, want %q", tt.name, tt.pairs, u, err, tt.url)
+		}
+	}
+
+	// errors
+	for _, pairs := range [][]string{nil, {"id"}, {"id", "abc"}} {
+		if _, err := r.URL("user.show", pairs...); err == nil {
+			t.Errorf("URL(user.show, %v): expect error", pairs)
+		}
+	}
+	if _, err := r.URL("none"); err == nil {
+		t.Errorf("URL(none): expect error")
+	}
+}
+
+func TestRouterPathPolicy(t *testing.T) {
+	r := NewRouter()
+	r.Get("/index", testHandler(200))
+	r.Get("/dir/", testHandler(200))
+	r.Post("/form", testHandler(201))
+	r.Get("/files/*path", testHandler(200))
+
+	tests := []struct {
+		policy   int
+		method   string
+		url      string
+		status   int
+		location string
+	}{
+		{SLASH_LENIENT, "GET", "/index/", 200, ""},
+		{SLASH_LENIENT, "GET", "/dir", 200, ""},
+		{SLASH_LENIENT, "GET", "//index", 301, "/index"},
+		{SLASH_LENIENT, "GET", "/a/../index?x=1", 301, "/index?x=1"},
+		{SLASH_LENIENT, "POST", "/./form", 308, "/form"},
+		{SLASH_STRICT, "GET", "/index", 200, ""},
+		{SLASH_STRICT, "GET", "/index/", 404, ""},
+		{SLASH_STRICT, "GET", "/dir", 404, ""},
+		{SLASH_STRICT, "GET", "/files/a/", 200, ""},
+		{SLASH_REDIRECT, "GET", "/index/", 301, "/index"},
+		{SLASH_REDIRECT, "GET", "/dir?x=1", 301, "/dir/?x=1"},
+		{SLASH_REDIRECT, "POST", "/form/", 308, "/form"},
+	}
+	for _, tt := range tests {
+		r.SetSlashPolicy(tt.policy)
+		w := testServe(r, tt.method, "http://localhost"+tt.url)
+		if w.Code != tt.status {
+			t.Errorf("policy %d, %s %s: got %d, want %d", tt.policy, tt.method, tt.url, w.Code, tt.status)
+		}
+		if loc := w.Header().Get("Location"); loc != tt.location {
+			t.Errorf("policy %d, %s %s: Location %q, want %q", tt.policy, tt.method, tt.url, loc, tt.location)
+		}
+	}
+
+	// no clean
+	r.SetSlashPolicy(SLASH_LENIENT)
+	r.SetCleanPath(false)
+	if w := testServe(r, "GET", "http://localhost/a/../index"); w.Code != 404 {
+		t.Errorf("no clean: got %d, want 404", w.Code)
+	}
+}
+
+func TestRTreeRadix(t *testing.T) {
+	routes := []string{
+		"/user",
+		"/users",
+		"/users/:id",
+		"/user-groups/:gid/users/:id",
+		"/u",
+		"/",
+		"/api/v1/users/all",
+		"/api/v1/users/:id<int>",
+		"/api/v2/*path",
+	}
+	testRTree(t, routes, []routeTest{
+		{"/user", 1, nil},
+		{"/users", 2, nil},
+		{"/users/", 2, nil},
+		{"/users/7", 3, map[string]string{"id": "7"}},
+		{"/user-groups/3/users/7", 4, map[string]string{"gid": "3", "id": "7"}},
+		{"/user-groups/3/users", 0, nil},
+		{"/u", 5, nil},
+		{"/us", 0, nil},
+		{"/", 6, nil},
+		{"/api/v1/users/all", 7, nil},
+		{"/api/v1/users/7", 8, map[string]string{"id": "7"}},
+		{"/api/v1/users/al", 0, nil},
+		{"/api/v1/users/all/x", 0, nil},
+		{"/api/v2/a/b/", 9, map[string]string{"path": "a/b"}},
+		{"/api/v2", 0, nil},
+	})
+
+	// params limit
+	p := ""
+	for i := 0; i <= MAX_ROUTE_PARAMS; i++ {
+		p += fmt.Sprintf("/:p%d", i)
+	}
+	if err := NewRTree().Add(p, testRoute(1)); err != ErrTooManyParams {
+		t.Errorf("too many params: got %v", err)
+	}
+}
+
+func TestRTreeConcurrent(t *testing.T) {
+	rt := NewRTree()
+	rt.Add("/a/:id", testRoute(1))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			p := fmt.Sprintf("/b/%d", i)
+			rt.Add(p, testRoute(2))
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		if _, r := rt.Match("/a/1"); r == nil {
+			t.Fatal("Match(/a/1): not found while adding")
+		}
+	}
+	<-done
+	if _, r := rt.Match("/b/99"); r == nil {
+		t.Error("Match(/b/99): not found after add")
+	}
+
+	// failed add leaves tree untouched
+	if err := rt.Add("/a/:id/*x/y", testRoute(3)); err == nil {
+		t.Fatal("expect error")
+	}
+	if _, r := rt.Match("/a/1/x/y"); r != nil {
+		t.Error("failed add changed tree")
+	}
+}
+
+// route set for benchmarks, like a small rest api
+func benchRTree() *RTree {
+	rt := NewRTree()
+	for _, res := range []string{"users", "posts", "comments", "tags", "files", "orders", "items", "groups"} {
+		for _, p := range []string{
+			"/api/v1/" + res,
+			"/api/v1/" + res + "/:id<int>",
+			"/api/v1/" + res + "/:id<int>/edit",
+			"/api/v1/" + res + "/:id/history/:rev",
+			"/admin/" + res,
+			"/admin/" + res + "/:id",
+		} {
+			rt.Add(p, newRoute("GET", p, testHandler(200), nil))
+		}
+	}
+	rt.Add("/static/*path", newRoute("GET", "/static/*path", testHandler(200), nil))
+	return rt
+}
+
+var benchPaths = []string{
+	"/api/v1/users",
+	"/api/v1/orders/42/edit",
+	"/api/v1/items/abc/history/3",
+	"/admin/groups/7",
+	"/static/js/app.js",
+	"/none/found",
+}
+
+func BenchmarkRTreeMatch(b *testing.B) {
+	rt := benchRTree()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rt.Match(benchPaths[i%len(benchPaths)])
+	}
+}
+
+func BenchmarkRTreeLookup(b *testing.B) {
+	rt := benchRTree()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ps := getRouteParams()
+		rt.lookup(benchPaths[i%len(benchPaths)], ps)
+		ps.release()
+	}
+}
+
+func BenchmarkRTreeMatchParallel(b *testing.B) {
+	rt := benchRTree()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			rt.Match(benchPaths[i%len(benchPaths)])
+			i++
+		}
+	})
+}
+
+func TestRouterRoutes(t *testing.T) {
+	r := NewRouter()
+	r.Post("/login", testHandler(200)).Name("login")
+	r.Get("/index", testHandler(200))
+	r.Group("/admin", &testGuard{401}).Get("/users", testHandler(200))
+	r.WS("/ws", testWSHandler)
+
+	routes := r.Routes()
+	want := []string{
+		"GET /admin/users guard",
+		"GET /index ",
+		"GET /ws ",
+		"POST /login ",
+	}
+	if len(routes) != len(want) {
+		t.Fatalf("got %d routes, want %d", len(routes), len(want))
+	}
+	for i, info := range routes {
+		got := fmt.Sprintf("%s %s %s", info.Method, info.Pattern, strings.Join(info.Middlewares, ","))
+		if got != want[i] {
+			t.Errorf("route %d: got %q, want %q", i, got, want[i])
+		}
+		if info.Pattern != "/ws" && !strings.Contains(info.Handler, "testHandler") {
+			t.Errorf("route %d: handler %q", i, info.Handler)
+		}
+	}
+	if !strings.HasSuffix(routes[2].Handler, "uweb.testWSHandler") {
+		t.Errorf("ws handler: got %q", routes[2].Handler)
+	}
+	if routes[3].Name != "login" {
+		t.Errorf("route name: got %q", routes[3].Name)
+	}
+}
+
+func testWSHandler(c *Context, conn *WSConn) {}
+
+func TestRouterHost(t *testing.T) {
+	r := NewRouter()
+	r.Get("/", testHandler(200))
+	r.Host("api.example.com").Get("/users/:id", testHandler(201))
+	r.Host(":tenant.example.com").Get("/", func(c *Context) (int, error) {
+		return 202, c.Res.Plain(c.Req.Params["tenant"])
+	})
+	r.Host(":a.:b.example.com").Get("/:id", func(c *Context) (int, error) {
+		return 203, c.Res.Plain(c.Req.Params["a"] + "," + c.Req.Params["b"] + "," + c.Req.Params["id"])
+	})
+
+	tests := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"http://localhost/", 200, "handler"},
+		{"http://api.example.com/users/7", 201, "handler"},
+		{"http://API.example.com:8080/users/7", 201, "handler"},
+		{"http://api.example.com/", 404, ""},
+		{"http://acme.example.com:8080/", 202, "acme"},
+		{"http://x.y.example.com/1", 203, "x,y,1"},
+		{"http://example.com/", 200, "handler"},
+	}
+	for _, tt := range tests {
+		w := testServe(r, "GET", tt.url)
+		if w.Code != tt.status {
+			t.Errorf("GET %s: got %d, want %d", tt.url, w.Code, tt.status)
+		}
+		if len(tt.body) > 0 && w.Body.String() != tt.body {
+			t.Errorf("GET %s: body %q, want %q", tt.url, w.Body.String(), tt.body)
+		}
+	}
+	if n := len(r.Routes()); n != 4 {
+		t.Errorf("Routes: got %d, want 4", n)
+	}
+}
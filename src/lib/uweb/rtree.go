@@ -0,0 +1,490 @@
+package uweb
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"lib/uuid"
+)
+
+//
+// Node kinds, also the match priority of siblings:
+// literal beats :param, and :param beats *catchall
+//
+const (
+	nodeStatic   = iota // literal bytes, eg. "api/v1/user"
+	nodeParam           // one segment param, eg. ":id" or ":id<int>"
+	nodeCatchAll        // trailing catch-all, eg. "*path"
+)
+
+//
+// Param constraints, the pattern is ":name<kind>", kind is one of
+// the builtin names below, otherwise it is a regexp which should match
+// the whole segment, eg. ":slug<[a-z0-9-]+>".
+//
+var paramChecks = map[string]func(string) bool{
+	"int": func(s string) bool {
+		// fast path, ParseInt allocates error on fail
+		digits := s
+		if len(digits) > 0 && (digits[0] == '-' || digits[0] == '+') {
+			digits = digits[1:]
+		}
+		if len(digits) == 0 {
+			return false
+		}
+		for i := 0; i < len(digits); i++ {
+			if digits[i] < '0' || digits[i] > '9' {
+				return false
+			}
+		}
+		if len(digits) < 19 {
+			return true
+		}
+		_, err := strconv.ParseInt(s, 10, 64)
+		return err == nil
+	},
+	"uuid": func(s string) bool {
+		return uuid.Parse(s) != nil
+	},
+}
+
+// get node kind by pattern
+func nodeKind(pattern string) int {
+	switch pattern[0] {
+	case ':':
+		return nodeParam
+	case '*':
+		return nodeCatchAll
+	}
+	return nodeStatic
+}
+
+// -----------------------------------------------------------------------------
+// params
+
+// Maxium params in one route pattern
+const MAX_ROUTE_PARAMS = 16
+
+// Matched param, value is a slice of path
+type routeParam struct {
+	key   string
+	value string
+}
+
+//
+// Matched params in fixed size array, pooled to avoid allocation
+//
+type routeParams struct {
+	n  int
+	ps [MAX_ROUTE_PARAMS]routeParam
+}
+
+var routeParamsPool = sync.Pool{
+	New: func() interface{} {
+		return new(routeParams)
+	},
+}
+
+func getRouteParams() *routeParams {
+	return routeParamsPool.Get().(*routeParams)
+}
+
+// Put back to pool, do not hold path any more
+func (ps *routeParams) release() {
+	for i := 0; i < ps.n; i++ {
+		ps.ps[i] = routeParam{}
+	}
+	ps.n = 0
+	routeParamsPool.Put(ps)
+}
+
+func (ps *routeParams) add(key, value string) {
+	ps.ps[ps.n] = routeParam{key, value}
+	ps.n++
+}
+
+// Convert to map, nil if no params.
+// Outer param wins if names are dup, eg. "/a/:id/b/:id"
+func (ps *routeParams) params() Params {
+	if ps.n == 0 {
+		return nil
+	}
+	m := make(Params, ps.n)
+	for i := ps.n - 1; i >= 0; i-- {
+		m[ps.ps[i].key] = ps.ps[i].value
+	}
+	return m
+}
+
+// -----------------------------------------------------------------------------
+// node
+
+//
+// Tree node of compressed radix tree.
+//
+// Static nodes hold bytes of path, and may cross slashes, eg. "api/v1/".
+// Their static children are indexed by first byte. Param and catch-all
+// nodes hold one segment pattern, and only hang on a node which ends at
+// a segment boundary.
+//
+type RNode struct {
+	kind    int               // static, param or catch-all
+	path    string            // static bytes, or segment pattern
+	name    string            // param name, without ':' and constraint
+	check   func(string) bool // param constraint, nil if none
+	indices string            // first byte of static children
+	statics []*RNode          // static children, same order as indices
+	params  []*RNode          // param children, constrained first
+	wild    *RNode            // catch-all child
+	route   *Route            // route ends at this node
+}
+
+var (
+	ErrDupPath          = errors.New("RNode: dup path")
+	ErrEmptySegment     = errors.New("RNode: empty segment")
+	ErrCatchAllPos      = errors.New("RNode: catch-all should be the last segment")
+	ErrCatchAllConflict = errors.New("RNode: conflict catch-all")
+	ErrTooManyParams    = errors.New("RNode: too many params")
+)
+
+// Create node with static path or segment pattern
+func newRNode(pattern string) (*RNode, error) {
+	if len(pattern) == 0 {
+		return nil, ErrEmptySegment
+	}
+	n := &RNode{
+		kind: nodeKind(pattern),
+		path: pattern,
+	}
+	if n.kind == nodeStatic {
+		return n, nil
+	}
+
+	// name and constraint
+	n.name = pattern[1:]
+	if i := strings.IndexByte(n.name, '<'); i != -1 {
+		if n.kind != nodeParam || !strings.HasSuffix(n.name, ">") {
+			return nil, fmt.Errorf("RNode: bad constraint in %q", pattern)
+		}
+		expr := n.name[i+1 : len(n.name)-1]
+		n.name = n.name[:i]
+		if f, ok := paramChecks[expr]; ok {
+			n.check = f
+		} else {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("RNode: bad constraint in %q, %s", pattern, err)
+			}
+			n.check = re.MatchString
+		}
+	}
+	if len(n.name) == 0 {
+		return nil, fmt.Errorf("RNode: empty param name in %q", pattern)
+	}
+	return n, nil
+}
+
+// Dump internal status
+func (n *RNode) Dump(indent string) {
+	// dump self
+	if len(indent) == 0 {
+		indent = " "
+	}
+	fmt.Printf("%s path:%q, route:%v, child:%d\n", indent, n.path, n.route != nil, len(n.statics)+len(n.params))
+
+	// dump child
+	for _, c := range n.statics {
+		c.Dump(indent + indent)
+	}
+	for _, c := range n.params {
+		c.Dump(indent + indent)
+	}
+	if n.wild != nil {
+		n.wild.Dump(indent + indent)
+	}
+}
+
+// Split trimmed pattern into static bytes and param segments, eg.
+// "user/:id<int>/edit" => "user/", ":id<int>", "/edit"
+func tokenize(pattern string) ([]string, error) {
+	if len(pattern) == 0 {
+		return nil, nil
+	}
+	var toks []string
+	static, params := "", 0
+	segs := strings.Split(pattern, "/")
+	for i, seg := range segs {
+		last := i == len(segs)-1
+		if len(seg) == 0 {
+			return nil, ErrEmptySegment
+		}
+		if kind := nodeKind(seg); kind != nodeStatic {
+			if kind == nodeCatchAll && !last {
+				return nil, ErrCatchAllPos
+			}
+			if params++; params > MAX_ROUTE_PARAMS {
+				return nil, ErrTooManyParams
+			}
+			if len(static) > 0 {
+				toks = append(toks, static)
+			}
+			toks = append(toks, seg)
+			static = ""
+			if !last {
+				static = "/"
+			}
+			continue
+		}
+		static += seg
+		if !last {
+			static += "/"
+		}
+	}
+	if len(static) > 0 {
+		toks = append(toks, static)
+	}
+	return toks, nil
+}
+
+// Add tokens under node
+func (n *RNode) add(toks []string, route *Route) error {
+	// ends here
+	if len(toks) == 0 {
+		if n.route != nil {
+			return ErrDupPath
+		}
+		n.route = route
+		return nil
+	}
+
+	tok := toks[0]
+	switch nodeKind(tok) {
+	case nodeParam:
+		for _, c := range n.params {
+			if c.path == tok {
+				return c.add(toks[1:], route)
+			}
+		}
+		c, err := newRNode(tok)
+		if err != nil {
+			return err
+		}
+		n.insertParam(c)
+		return c.add(toks[1:], route)
+
+	case nodeCatchAll:
+		if n.wild != nil {
+			if n.wild.path != tok {
+				return ErrCatchAllConflict
+			}
+			return n.wild.add(toks[1:], route)
+		}
+		c, err := newRNode(tok)
+		if err != nil {
+			return err
+		}
+		n.wild = c
+		return c.add(toks[1:], route)
+	}
+	return n.addStatic(tok, toks[1:], route)
+}
+
+// Add static bytes under node, split child on common prefix
+func (n *RNode) addStatic(s string, toks []string, route *Route) error {
+	i := strings.IndexByte(n.indices, s[0])
+	if i == -1 {
+		c, _ := newRNode(s)
+		n.indices += s[:1]
+		n.statics = append(n.statics, c)
+		return c.add(toks, route)
+	}
+
+	// common prefix
+	c := n.statics[i]
+	l := 0
+	for l < len(s) && l < len(c.path) && s[l] == c.path[l] {
+		l++
+	}
+
+	// split child, the tail takes over its children and route
+	if l < len(c.path) {
+		tail := *c
+		tail.path = c.path[l:]
+		*c = RNode{
+			kind:    nodeStatic,
+			path:    c.path[:l],
+			indices: tail.path[:1],
+			statics: []*RNode{&tail},
+		}
+	}
+	if l == len(s) {
+		return c.add(toks, route)
+	}
+	return c.addStatic(s[l:], toks, route)
+}
+
+// Insert param child, constrained before free one, keep insert order
+func (n *RNode) insertParam(c *RNode) {
+	i := len(n.params)
+	if c.check != nil {
+		for i > 0 && n.params[i-1].check == nil {
+			i--
+		}
+	}
+	n.params = append(n.params, nil)
+	copy(n.params[i+1:], n.params[i:])
+	n.params[i] = c
+}
+
+// Deep copy node and its children, routes are shared
+func (n *RNode) clone() *RNode {
+	c := *n
+	if len(n.statics) > 0 {
+		c.statics = make([]*RNode, len(n.statics))
+		for i, child := range n.statics {
+			c.statics[i] = child.clone()
+		}
+	}
+	if len(n.params) > 0 {
+		c.params = make([]*RNode, len(n.params))
+		for i, child := range n.params {
+			c.params[i] = child.clone()
+		}
+	}
+	if n.wild != nil {
+		c.wild = n.wild.clone()
+	}
+	return &c
+}
+
+// Visit all routes in subtree
+func (n *RNode) walk(f func(*Route)) {
+	if n.route != nil {
+		f(n.route)
+	}
+	for _, c := range n.statics {
+		c.walk(f)
+	}
+	for _, c := range n.params {
+		c.walk(f)
+	}
+	if n.wild != nil {
+		n.wild.walk(f)
+	}
+}
+
+// Match the rest of path after this node, backtrack on fail
+func (n *RNode) match(path string, ps *routeParams) *Route {
+	// if no path to match
+	if len(path) == 0 {
+		return n.route
+	}
+
+	// static by first byte
+	if i := strings.IndexByte(n.indices, path[0]); i != -1 {
+		c := n.statics[i]
+		if strings.HasPrefix(path, c.path) {
+			if r := c.match(path[len(c.path):], ps); r != nil {
+				return r
+			}
+		}
+	}
+
+	// param eats one segment
+	if len(n.params) > 0 {
+		end := strings.IndexByte(path, '/')
+		if end == -1 {
+			end = len(path)
+		}
+		seg := path[:end]
+		for _, c := range n.params {
+			if c.check != nil && !c.check(seg) {
+				continue
+			}
+			mark := ps.n
+			ps.add(c.name, seg)
+			if r := c.match(path[end:], ps); r != nil {
+				return r
+			}
+			ps.n = mark
+		}
+	}
+
+	// catch-all eats the rest of path
+	if n.wild != nil {
+		ps.add(n.wild.name, path)
+		return n.wild.route
+	}
+
+	// fail
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// tree
+
+//
+// RTree is path router tree, for fast match.
+//
+// Routes are added in init and rarely change, so Add clones the tree,
+// merges path to the clone and swaps it in atomically, then Match just
+// loads current root without any lock.
+//
+// Leading and trailing slashes are trimmed before add and match.
+//
+type RTree struct {
+	mu   sync.Mutex   // serialise Add only
+	root atomic.Value // *RNode, never changed after stored
+}
+
+// Create a tree with an empty root node
+func NewRTree() *RTree {
+	rt := new(RTree)
+	rt.root.Store(&RNode{kind: nodeStatic})
+	return rt
+}
+
+// get current root
+func (rt *RTree) load() *RNode {
+	return rt.root.Load().(*RNode)
+}
+
+// Add path to tree
+func (rt *RTree) Add(p string, r *Route) error {
+	toks, err := tokenize(strings.Trim(p, "/"))
+	if err != nil {
+		return err
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	// copy on write, a failed Add leaves the tree untouched
+	root := rt.load().clone()
+	if err := root.add(toks, r); err != nil {
+		return err
+	}
+	rt.root.Store(root)
+	return nil
+}
+
+// Match path and fill params, no allocation
+func (rt *RTree) lookup(p string, ps *routeParams) *Route {
+	return rt.load().match(strings.Trim(p, "/"), ps)
+}
+
+// Match path and get route, params is nil if none
+func (rt *RTree) Match(p string) (map[string]string, *Route) {
+	ps := getRouteParams()
+	defer ps.release()
+
+	if r := rt.lookup(p, ps); r != nil {
+		return ps.params(), r
+	}
+	return nil, nil
+}
@@ -0,0 +1,358 @@
+package uweb
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// part size of multipart upload, S3 requires at least 5M
+	// except the last part, memory used by each Put
+	S3_PART_SIZE = 5 << 20
+)
+
+//
+// S3 compatible storage config
+//
+type S3Config struct {
+	// eg. "https://s3.amazonaws.com", "http://127.0.0.1:9000"
+	Endpoint string
+	Region   string
+	Bucket   string
+
+	AccessKey string
+	SecretKey string
+
+	// bucket in path instead of host, for minio or local stubs
+	PathStyle bool
+
+	// optional, default http.DefaultClient
+	Client *http.Client
+}
+
+//
+// S3Error is the error response of S3
+//
+type S3Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("S3: %d %s %s", e.Status, e.Code, e.Message)
+}
+
+//
+// S3 compatible storage, signed by AWS Signature Version 4.
+// Put streams large files by multipart upload, S3_PART_SIZE a time.
+//
+type S3Storage struct {
+	cfg    S3Config
+	base   *url.URL
+	client *http.Client
+	now    func() time.Time
+}
+
+// Create S3 storage
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.Scheme) == 0 || len(u.Host) == 0 || len(cfg.Bucket) == 0 || len(cfg.Region) == 0 {
+		return nil, errors.New("S3: endpoint, region and bucket are required")
+	}
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &S3Storage{cfg, u, client, time.Now}, nil
+}
+
+// @impl Storage.Put
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	// small one in a request
+	buf := make([]byte, S3_PART_SIZE)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		h := http.Header{}
+		if len(contentType) > 0 {
+			h.Set("Content-Type", contentType)
+		}
+		return s.ok(s.do(ctx, "PUT", key, nil, buf[:n], h))
+	}
+	if err != nil {
+		return err
+	}
+
+	// multipart
+	uploadId, err := s.createMultipart(ctx, key, contentType)
+	if err != nil {
+		return err
+	}
+	var etags []string
+	for n > 0 {
+		q := url.Values{"partNumber": {strconv.Itoa(len(etags) + 1)}, "uploadId": {uploadId}}
+		res, err := s.do(ctx, "PUT", key, q, buf[:n], nil)
+		if err = s.ok(res, err); err != nil {
+			s.abortMultipart(ctx, key, uploadId)
+			return err
+		}
+		etags = append(etags, res.Header.Get("ETag"))
+
+		n, err = io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			s.abortMultipart(ctx, key, uploadId)
+			return err
+		}
+	}
+	if err := s.completeMultipart(ctx, key, uploadId, etags); err != nil {
+		s.abortMultipart(ctx, key, uploadId)
+		return err
+	}
+	return nil
+}
+
+// @impl Storage.Get
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	res, err := s.do(ctx, "GET", key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		return nil, s.ok(res, nil)
+	}
+	return res.Body, nil
+}
+
+// @impl Storage.Delete
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	err := s.ok(s.do(ctx, "DELETE", key, nil, nil, nil))
+	if err == ErrStorageNotExist {
+		return nil
+	}
+	return err
+}
+
+func (s *S3Storage) createMultipart(ctx context.Context, key, contentType string) (string, error) {
+	h := http.Header{}
+	if len(contentType) > 0 {
+		h.Set("Content-Type", contentType)
+	}
+	res, err := s.do(ctx, "POST", key, url.Values{"uploads": {""}}, nil, h)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != 200 {
+		return "", s.ok(res, nil)
+	}
+	defer res.Body.Close()
+	var result struct {
+		UploadId string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.UploadId, nil
+}
+
+func (s *S3Storage) completeMultipart(ctx context.Context, key, uploadId string, etags []string) error {
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	var body struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}
+	for i, etag := range etags {
+		body.Parts = append(body.Parts, part{i + 1, etag})
+	}
+	data, err := xml.Marshal(body)
+	if err != nil {
+		return err
+	}
+	res, err := s.do(ctx, "POST", key, url.Values{"uploadId": {uploadId}}, data, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// S3 may return 200 with an error body
+	b, _ := io.ReadAll(res.Body)
+	e := &S3Error{Status: res.StatusCode}
+	if res.StatusCode != 200 || (xml.Unmarshal(b, e) == nil && len(e.Code) > 0) {
+		return e
+	}
+	return nil
+}
+
+// abort even if ctx is canceled
+func (s *S3Storage) abortMultipart(ctx context.Context, key, uploadId string) {
+	ctx = context.WithoutCancel(ctx)
+	s.ok(s.do(ctx, "DELETE", key, url.Values{"uploadId": {uploadId}}, nil, nil))
+}
+
+// close response, and convert error status
+func (s *S3Storage) ok(res *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 300 {
+		return nil
+	}
+	if res.StatusCode == 404 {
+		return ErrStorageNotExist
+	}
+	e := &S3Error{Status: res.StatusCode}
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	xml.Unmarshal(b, e)
+	return e
+}
+
+// signed request of object key
+func (s *S3Storage) do(ctx context.Context, method, key string, q url.Values, body []byte, h http.Header) (*http.Response, error) {
+	// url
+	u := *s.base
+	p := "/" + key
+	if s.cfg.PathStyle {
+		p = "/" + s.cfg.Bucket + p
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + p
+	u.RawPath = strings.TrimSuffix(s.base.EscapedPath(), "/") + awsEscape(p, true)
+	u.RawQuery = awsQuery(q)
+
+	// req
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for k, vs := range h {
+		req.Header[k] = vs
+	}
+	sum := sha256.Sum256(body)
+	s.sign(req, hex.EncodeToString(sum[:]), s.now())
+	return s.client.Do(req)
+}
+
+// AWS Signature Version 4, see:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (s *S3Storage) sign(req *http.Request, payloadHash string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// headers
+	host := req.Host
+	if len(host) == 0 {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, vs := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-type" || lk == "content-md5" || lk == "range" {
+			headers[lk] = strings.TrimSpace(strings.Join(vs, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// canonical request
+	creq := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		awsQuery(req.URL.Query()),
+		canonHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	creqSum := sha256.Sum256([]byte(creq))
+
+	// sign
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	sts := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(creqSum[:])
+	k := hmacSha256([]byte("AWS4"+s.cfg.SecretKey), date)
+	k = hmacSha256(k, s.cfg.Region)
+	k = hmacSha256(k, "s3")
+	k = hmacSha256(k, "aws4_request")
+	sig := hex.EncodeToString(hmacSha256(k, sts))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, sig))
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sorted and escaped query, "uploads=" for empty value
+func awsQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, awsEscape(k, false)+"="+awsEscape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// escape all but unreserved chars, and slash if keep
+func awsEscape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && keepSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
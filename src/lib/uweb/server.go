@@ -1,29 +1,45 @@
 //
-// copy from: https://raw.githubusercontent.com/tylerb/graceful/master/graceful.go
+// Graceful server, the API follows https://github.com/tylerb/graceful,
+// draining is done by http.Server.Shutdown
 //
 package uweb
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
-// Server wraps an http.Server with graceful connection handling.
-// It may be used directly in the same way as http.Server, or may
-// be constructed with the global functions in this package.
+// Server wraps an http.Server with graceful shutdown. On SIGINT,
+// SIGTERM or Stop, it:
+//
+//  1. fails readiness, see MdReady, and waits DrainDelay, so load
+//     balancers stop sending new requests
+//  2. closes ShutdownNotify channels, long lived requests should end
+//  3. stops listening and waits active requests and hijacked conns,
+//     closes them after Timeout
+//  4. runs shutdown hooks in the order added
+//
+// On SIGHUP, SIGUSR2 or Reload, it starts the binary again with the
+// listener, and shuts down as above once the new one is serving, so
+// no connection is refused on deploy. Listeners from systemd socket
+// activation are used too, see InheritedListeners.
 //
 // Example:
-//	srv := &graceful.Server{
+//	srv := &uweb.Server{
 //		Timeout: 5 * time.Second,
-//		Server: &http.Server{Addr: ":1234", Handler: handler},
+//		Server:  &http.Server{Addr: ":1234", Handler: handler},
 //	}
+//	srv.OnShutdown("session", flushSessions)
 //	srv.ListenAndServe()
 type Server struct {
 	*http.Server
@@ -32,27 +48,33 @@ type Server struct {
 	// before forcefully terminating them.
 	Timeout time.Duration
 
-	// Limit the number of outstanding requests
+	// DrainDelay is the duration readiness fails before draining, it
+	// should be longer than the health check interval of load balancer.
+	DrainDelay time.Duration
+
+	// HookTimeout is the deadline of all shutdown hooks, default Timeout.
+	HookTimeout time.Duration
+
+	// Limit the number of accepted connections, 0 for no limit.
 	ListenLimit int
 
 	// ConnState specifies an optional callback function that is
 	// called when a client connection changes state. This is a proxy
-	// to the underlying http.Server's ConnState, and the original
-	// must not be set directly.
+	// to the underlying http.Server's ConnState.
 	ConnState func(net.Conn, http.ConnState)
 
 	// BeforeShutdown is an optional callback function that is called
-	// before the listener is closed.
+	// when shutdown starts, before readiness fails.
 	BeforeShutdown func()
 
 	// ShutdownInitiated is an optional callback function that is called
-	// when shutdown is initiated. It can be used to notify the client
+	// before the listener is closed. It can be used to notify the client
 	// side of long lived connections (e.g. websockets) to reconnect.
 	ShutdownInitiated func()
 
 	// NoSignalHandling prevents graceful from automatically shutting down
-	// on SIGINT and SIGTERM. If set to true, you must shut down the server
-	// manually with Stop().
+	// on SIGINT and SIGTERM, and reloading on SIGHUP and SIGUSR2. If set
+	// to true, you must shut down the server manually with Stop().
 	NoSignalHandling bool
 
 	// Logger used to notify of errors on startup and on stop.
@@ -62,10 +84,13 @@ type Server struct {
 	// signal and is thus shutting down.
 	Interrupted bool
 
-	// interrupt signals the listener to stop serving connections,
-	// and the server to shut down.
+	// interrupt signals the server to shut down, or reload.
 	interrupt chan os.Signal
 
+	// listener passed to the new process on reload, before
+	// wrapped by tls or limit
+	listener net.Listener
+
 	// stopLock is used to protect against concurrent calls to Stop
 	stopLock sync.Mutex
 
@@ -76,8 +101,79 @@ type Server struct {
 	// chanLock is used to protect access to the various channel constructors.
 	chanLock sync.RWMutex
 
-	// connections holds all connections managed by graceful
-	connections map[net.Conn]struct{}
+	// closingChan is closed when shutdown is initiated, long lived
+	// requests get it from ShutdownNotify and should end
+	closingChan chan struct{}
+
+	// readiness fails once shutdown starts
+	notReady atomic.Bool
+
+	// shutdown is started by Stop or signal, Serve waits it
+	stopping atomic.Bool
+
+	// shutdown hooks, in order
+	hookLock sync.Mutex
+	hooks    []shutdownHook
+
+	// hijacked connections, such as websockets, are not tracked by
+	// http.Server, handlers add them, shutdown waits or closes them
+	hijackLock sync.Mutex
+	hijacked   map[net.Conn]struct{}
+	hijackWg   sync.WaitGroup
+}
+
+type shutdownHook struct {
+	name string
+	f    func(ctx context.Context) error
+}
+
+// key of Server in request context
+type serverKey struct{}
+
+// ShutdownNotify returns a channel closed when the Server serving the
+// request begins graceful shutdown, long lived handlers such as event
+// streams should end on it. It is nil if not served by Server, which
+// never receives.
+func ShutdownNotify(ctx context.Context) <-chan struct{} {
+	if srv, ok := ctx.Value(serverKey{}).(*Server); ok {
+		return srv.closing()
+	}
+	return nil
+}
+
+// Ready reports whether the Server serving the request is not shutting
+// down, true if not served by Server.
+func Ready(ctx context.Context) bool {
+	if srv, ok := ctx.Value(serverKey{}).(*Server); ok {
+		return !srv.notReady.Load()
+	}
+	return true
+}
+
+// Track hijacked conn of request, call the returned func after conn closed.
+// Shutdown waits for them and closes them on timeout.
+func trackHijacked(ctx context.Context, conn net.Conn) func() {
+	srv, ok := ctx.Value(serverKey{}).(*Server)
+	if !ok {
+		return func() {}
+	}
+	srv.hijackLock.Lock()
+	if srv.hijacked == nil {
+		srv.hijacked = make(map[net.Conn]struct{})
+	}
+	srv.hijacked[conn] = struct{}{}
+	srv.hijackWg.Add(1)
+	srv.hijackLock.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			srv.hijackLock.Lock()
+			delete(srv.hijacked, conn)
+			srv.hijackLock.Unlock()
+			srv.hijackWg.Done()
+		})
+	}
 }
 
 // Run serves the http.Handler with graceful shutdown enabled.
@@ -92,11 +188,8 @@ func Run(addr string, timeout time.Duration, n http.Handler) {
 	}
 
 	if err := srv.ListenAndServe(); err != nil {
-		if opErr, ok := err.(*net.OpError); !ok || (ok && opErr.Op != "accept") {
-			srv.Logger.Fatal(err)
-		}
+		srv.Logger.Fatal(err)
 	}
-
 }
 
 // RunWithErr is an alternative version of Run function which can return error.
@@ -124,12 +217,11 @@ func ListenAndServe(server *http.Server, timeout time.Duration) error {
 
 // ListenAndServe is equivalent to http.Server.ListenAndServe with graceful shutdown enabled.
 func (srv *Server) ListenAndServe() error {
-	// Create the listener so we can control their lifetime
 	addr := srv.Addr
 	if addr == "" {
 		addr = ":http"
 	}
-	l, err := net.Listen("tcp", addr)
+	l, err := srv.listen(addr)
 	if err != nil {
 		return err
 	}
@@ -150,7 +242,6 @@ func ListenAndServeTLS(server *http.Server, certFile, keyFile string, timeout ti
 // provided cert and key files. Use this method if you need access to the
 // listener object directly. When ready, pass it to the Serve method.
 func (srv *Server) ListenTLS(certFile, keyFile string) (net.Listener, error) {
-	// Create the listener ourselves so we can control its lifetime
 	addr := srv.Addr
 	if addr == "" {
 		addr = ":https"
@@ -158,7 +249,7 @@ func (srv *Server) ListenTLS(certFile, keyFile string) (net.Listener, error) {
 
 	config := &tls.Config{}
 	if srv.TLSConfig != nil {
-		*config = *srv.TLSConfig
+		config = srv.TLSConfig.Clone()
 	}
 	if config.NextProtos == nil {
 		config.NextProtos = []string{"http/1.1"}
@@ -171,13 +262,12 @@ func (srv *Server) ListenTLS(certFile, keyFile string) (net.Listener, error) {
 		return nil, err
 	}
 
-	conn, err := net.Listen("tcp", addr)
+	conn, err := srv.listen(addr)
 	if err != nil {
 		return nil, err
 	}
 
-	tlsListener := tls.NewListener(conn, config)
-	return tlsListener, nil
+	return tls.NewListener(conn, config), nil
 }
 
 // ListenAndServeTLS is equivalent to http.Server.ListenAndServeTLS with graceful shutdown enabled.
@@ -198,13 +288,12 @@ func (srv *Server) ListenAndServeTLSConfig(config *tls.Config) error {
 		addr = ":https"
 	}
 
-	conn, err := net.Listen("tcp", addr)
+	conn, err := srv.listen(addr)
 	if err != nil {
 		return err
 	}
 
-	tlsListener := tls.NewListener(conn, config)
-	return srv.Serve(tlsListener)
+	return srv.Serve(tls.NewListener(conn, config))
 }
 
 // Serve is equivalent to http.Server.Serve with graceful shutdown enabled.
@@ -217,61 +306,91 @@ func Serve(server *http.Server, l net.Listener, timeout time.Duration) error {
 }
 
 // Serve is equivalent to http.Server.Serve with graceful shutdown enabled.
+// It returns after shutdown is done, nil if it was graceful.
 func (srv *Server) Serve(listener net.Listener) error {
-	/*
-	if srv.ListenLimit != 0 {
-	   listener = netutil.LimitListener(listener, srv.ListenLimit)
-	}
-	*/
-	// Track connection state
-	add := make(chan net.Conn)
-	remove := make(chan net.Conn)
-
-	srv.Server.ConnState = func(conn net.Conn, state http.ConnState) {
-		switch state {
-		case http.StateNew:
-			add <- conn
-		case http.StateClosed, http.StateHijacked:
-			remove <- conn
-		}
-		if srv.ConnState != nil {
-			srv.ConnState(conn, state)
-		}
+	if _, ok := listener.(filer); ok {
+		srv.listener = listener
+	}
+	if srv.ListenLimit > 0 {
+		listener = newLimitListener(listener, srv.ListenLimit)
 	}
 
-	// Manage open connections
-	shutdown := make(chan chan struct{})
-	kill := make(chan struct{})
-	go srv.manageConnections(add, remove, shutdown, kill)
+	// Pass server to requests, for ShutdownNotify, Ready and trackHijacked
+	baseContext := srv.Server.BaseContext
+	srv.Server.BaseContext = func(l net.Listener) context.Context {
+		ctx := context.Background()
+		if baseContext != nil {
+			ctx = baseContext(l)
+		}
+		return context.WithValue(ctx, serverKey{}, srv)
+	}
+	if srv.ConnState != nil {
+		srv.Server.ConnState = srv.ConnState
+	}
 
-	interrupt := srv.interruptChan()
 	// Set up the interrupt handler
+	interrupt := srv.interruptChan()
 	if !srv.NoSignalHandling {
-		signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+		signal.Notify(interrupt, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM}, reloadSignals...)...)
+		defer signal.Stop(interrupt)
 	}
-	quitting := make(chan struct{})
-	go srv.handleInterrupt(interrupt, quitting, listener)
+	done, quit := make(chan struct{}), make(chan struct{})
+	defer close(quit)
+	go srv.handleInterrupt(interrupt, done, quit)
 
-	// Serve with graceful listener.
-	// Execution blocks here until listener.Close() is called, above.
+	// Blocks until Shutdown or Close is called, waits shutdown done if
+	// it's by Stop or signal
+	notifyReady()
 	err := srv.Server.Serve(listener)
-	if err != nil {
-		// If the underlying listening is closed, Serve returns an error
-		// complaining about listening on a closed socket. This is expected, so
-		// let's ignore the error if we are the ones who explicitly closed the
-		// socket.
-		select {
-		case <-quitting:
-			err = nil
-		default:
+	if err == http.ErrServerClosed {
+		if srv.stopping.Load() {
+			<-done
 		}
+		return nil
 	}
+	return err
+}
 
-	srv.shutdown(shutdown, kill)
+// Shutdown shuts down gracefully as Stop, and waits it done. It returns
+// ctx.Err() if ctx is done first, shutdown goes on until Timeout, or
+// ctx deadline if it's earlier.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	timeout := srv.Timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline); timeout == 0 || left < timeout {
+			timeout = left
+		}
+		if timeout <= 0 {
+			timeout = time.Nanosecond // 0 means no timeout
+		}
+	}
+	srv.Stop(timeout)
+	select {
+	case <-srv.StopChan():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
+// Close closes listeners and connections, hijacked ones too, right
+// now, shutdown hooks are not run. Serve returns nil.
+func (srv *Server) Close() error {
+	err := srv.Server.Close()
+	srv.closeHijacked()
 	return err
 }
 
+// Add a hook run after requests are drained, hooks run in the order
+// added, such as stopping jobs, flushing sessions, then closing cache
+// clients. ctx is done on HookTimeout, errors are logged.
+func (srv *Server) OnShutdown(name string, f func(ctx context.Context) error) {
+	srv.hookLock.Lock()
+	defer srv.hookLock.Unlock()
+
+	srv.hooks = append(srv.hooks, shutdownHook{name, f})
+}
+
 // Stop instructs the type to halt operations and close
 // the stop channel when it is finished.
 //
@@ -284,8 +403,22 @@ func (srv *Server) Stop(timeout time.Duration) {
 	defer srv.stopLock.Unlock()
 
 	srv.Timeout = timeout
-	interrupt := srv.interruptChan()
-	interrupt <- syscall.SIGINT
+	select {
+	case srv.interruptChan() <- syscall.SIGINT:
+	default:
+		// a signal is pending already
+	}
+}
+
+// Reload starts the binary again with the listener, and stops
+// once it's serving, as on SIGHUP. The server keeps serving if the
+// new one fails.
+func (srv *Server) Reload() {
+	select {
+	case srv.interruptChan() <- syscall.SIGHUP:
+	default:
+		// a signal is pending already
+	}
 }
 
 // StopChan gets the stop channel which will block until
@@ -307,35 +440,6 @@ func DefaultLogger() *log.Logger {
 	return log.New(os.Stderr, "[graceful] ", 0)
 }
 
-func (srv *Server) manageConnections(add, remove chan net.Conn, shutdown chan chan struct{}, kill chan struct{}) {
-	var done chan struct{}
-	srv.connections = map[net.Conn]struct{}{}
-	for {
-		select {
-		case conn := <-add:
-			srv.connections[conn] = struct{}{}
-		case conn := <-remove:
-			delete(srv.connections, conn)
-			if done != nil && len(srv.connections) == 0 {
-				done <- struct{}{}
-				return
-			}
-		case done = <-shutdown:
-			if len(srv.connections) == 0 {
-				done <- struct{}{}
-				return
-			}
-		case <-kill:
-			for k := range srv.connections {
-				if err := k.Close(); err != nil {
-					srv.log("[ERROR] %s", err)
-				}
-			}
-			return
-		}
-	}
-}
-
 func (srv *Server) interruptChan() chan os.Signal {
 	srv.chanLock.Lock()
 	defer srv.chanLock.Unlock()
@@ -347,27 +451,59 @@ func (srv *Server) interruptChan() chan os.Signal {
 	return srv.interrupt
 }
 
-func (srv *Server) handleInterrupt(interrupt chan os.Signal, quitting chan struct{}, listener net.Listener) {
-	for _ = range interrupt {
+// inherited listener, or a new one
+func (srv *Server) listen(addr string) (net.Listener, error) {
+	l, err := takeInherited()
+	if err != nil {
+		return nil, err
+	}
+	if l == nil {
+		if l, err = net.Listen("tcp", addr); err != nil {
+			return nil, err
+		}
+	}
+	srv.listener = l
+	return l, nil
+}
+
+func (srv *Server) closing() chan struct{} {
+	srv.chanLock.Lock()
+	defer srv.chanLock.Unlock()
+
+	if srv.closingChan == nil {
+		srv.closingChan = make(chan struct{})
+	}
+
+	return srv.closingChan
+}
+
+// handle signals until Serve returns, done is closed after shutdown
+func (srv *Server) handleInterrupt(interrupt chan os.Signal, done, quit chan struct{}) {
+	for {
+		var sig os.Signal
+		select {
+		case sig = <-interrupt:
+		case <-quit:
+			return
+		}
 		if srv.Interrupted {
 			srv.log("already shutting down")
 			continue
 		}
+		if sig != syscall.SIGINT && sig != syscall.SIGTERM {
+			srv.log("reload initiated")
+			if err := srv.reload(); err != nil {
+				srv.log("[ERROR] reload: %s", err)
+				continue
+			}
+		}
 		srv.log("shutdown initiated")
 		srv.Interrupted = true
-		if srv.BeforeShutdown != nil {
-			srv.BeforeShutdown()
-		}
-
-		close(quitting)
-		srv.SetKeepAlivesEnabled(false)
-		if err := listener.Close(); err != nil {
-			srv.log("[ERROR] %s", err)
-		}
-
-		if srv.ShutdownInitiated != nil {
-			srv.ShutdownInitiated()
-		}
+		srv.stopping.Store(true)
+		go func() {
+			srv.shutdown()
+			close(done)
+		}()
 	}
 }
 
@@ -377,24 +513,149 @@ func (srv *Server) log(fmt string, v ...interface{}) {
 	}
 }
 
-func (srv *Server) shutdown(shutdown chan chan struct{}, kill chan struct{}) {
-	// Request done notification
-	done := make(chan struct{})
-	shutdown <- done
+func (srv *Server) closeHijacked() {
+	srv.hijackLock.Lock()
+	defer srv.hijackLock.Unlock()
 
-	if srv.Timeout > 0 {
-		select {
-		case <-done:
-		case <-time.After(srv.Timeout):
-			close(kill)
+	for k := range srv.hijacked {
+		if err := k.Close(); err != nil {
+			srv.log("[ERROR] %s", err)
 		}
-	} else {
-		<-done
 	}
-	// Close the stopChan to wake up any blocked goroutines.
+}
+
+func (srv *Server) shutdown() {
+	if srv.BeforeShutdown != nil {
+		srv.BeforeShutdown()
+	}
+
+	// fail readiness, and keep serving until load balancers see it
+	srv.notReady.Store(true)
+	if srv.DrainDelay > 0 {
+		time.Sleep(srv.DrainDelay)
+	}
+
+	// long lived requests should end
+	close(srv.closing())
+	if srv.ShutdownInitiated != nil {
+		srv.ShutdownInitiated()
+	}
+
+	// drain, hijacked ones are closed by handlers after
+	// ShutdownNotify, or killed on timeout
+	ctx := context.Background()
+	if srv.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, srv.Timeout)
+		defer cancel()
+	}
+	if err := srv.Server.Shutdown(ctx); err != nil {
+		srv.log("[ERROR] shutdown: %s", err)
+		srv.Server.Close()
+	}
+	hijackDone := make(chan struct{})
+	go func() {
+		srv.hijackWg.Wait()
+		close(hijackDone)
+	}()
+	select {
+	case <-hijackDone:
+	case <-ctx.Done():
+		srv.closeHijacked()
+	}
+
+	srv.runHooks()
+
+	// Close the stopChan to wake up any blocked goroutines,
+	// and later callers of StopChan.
 	srv.chanLock.Lock()
-	if srv.stopChan != nil {
-		close(srv.stopChan)
+	if srv.stopChan == nil {
+		srv.stopChan = make(chan struct{})
 	}
+	close(srv.stopChan)
 	srv.chanLock.Unlock()
-}
\ No newline at end of file
+}
+
+// run hooks in order, with HookTimeout
+func (srv *Server) runHooks() {
+	srv.hookLock.Lock()
+	hooks := srv.hooks
+	srv.hookLock.Unlock()
+	if len(hooks) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	timeout := srv.HookTimeout
+	if timeout == 0 {
+		timeout = srv.Timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	for _, h := range hooks {
+		if err := h.f(ctx); err != nil {
+			srv.log("[ERROR] shutdown hook %s: %s", h.name, err)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+// limit listener
+
+var errListenerClosed = errors.New("Server: listener closed")
+
+//
+// Listener accepts at most n connections at the same time,
+// as golang.org/x/net/netutil.LimitListener
+//
+type limitListener struct {
+	net.Listener
+	sem       chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newLimitListener(l net.Listener, n int) *limitListener {
+	return &limitListener{
+		Listener: l,
+		sem:      make(chan struct{}, n),
+		done:     make(chan struct{}),
+	}
+}
+
+// wait a slot, then accept
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.sem <- struct{}{}:
+	case <-l.done:
+		return nil, errListenerClosed
+	}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.sem }}, nil
+}
+
+func (l *limitListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+	return err
+}
+
+// releases its slot once closed
+type limitConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
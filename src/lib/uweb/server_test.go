@@ -0,0 +1,215 @@
+package uweb
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServerShutdown(t *testing.T) {
+	started := make(chan struct{})
+	r := NewRouter()
+	r.Get("/slow", func(c *Context) (int, error) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		return 200, c.Res.Plain("done")
+	})
+	app := NewApp()
+	app.Use(MdReady("/ready"))
+	app.Use(r)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + l.Addr().String()
+	srv := &Server{
+		Timeout:          time.Second,
+		DrainDelay:       200 * time.Millisecond,
+		Server:           &http.Server{Handler: app},
+		NoSignalHandling: true,
+	}
+	var mu sync.Mutex
+	var hooks []string
+	for _, name := range []string{"jobs", "session", "cache"} {
+		name := name
+		srv.OnShutdown(name, func(ctx context.Context) error {
+			mu.Lock()
+			hooks = append(hooks, name)
+			mu.Unlock()
+			return nil
+		})
+	}
+	served := make(chan error)
+	go func() { served <- srv.Serve(l) }()
+
+	get := func(p string) (int, string) {
+		res, err := http.Get(url + p)
+		if err != nil {
+			return 0, err.Error()
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(b)
+	}
+	if code, _ := get("/ready"); code != 200 {
+		t.Fatal("ready:", code)
+	}
+
+	// in flight one finishes
+	slow := make(chan string)
+	go func() {
+		_, body := get("/slow")
+		slow <- body
+	}()
+	<-started
+	srv.Stop(time.Second)
+
+	// readiness fails, still serving
+	time.Sleep(50 * time.Millisecond)
+	if code, _ := get("/ready"); code != 503 {
+		t.Error("ready while draining:", code)
+	}
+	if body := <-slow; body != "done" {
+		t.Error("slow:", body)
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("not stopped")
+	}
+	<-srv.StopChan()
+	if strings.Join(hooks, ",") != "jobs,session,cache" {
+		t.Error("hooks:", hooks)
+	}
+	if _, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		t.Error("still listening")
+	}
+}
+
+func TestServerTimeout(t *testing.T) {
+	handled := make(chan struct{})
+	r := NewRouter()
+	r.Get("/hang", func(c *Context) (int, error) {
+		defer close(handled)
+		<-c.Ctx().Done()
+		return 200, nil
+	})
+	app := NewApp()
+	app.Use(r)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	srv := &Server{Timeout: 100 * time.Millisecond, Server: &http.Server{Handler: app}, NoSignalHandling: true}
+	served := make(chan error)
+	go func() { served <- srv.Serve(l) }()
+
+	failed := make(chan error)
+	go func() {
+		_, err := http.Get("http://" + l.Addr().String() + "/hang")
+		failed <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	srv.Stop(100 * time.Millisecond)
+	select {
+	case err := <-failed:
+		if err == nil {
+			t.Error("hanging request not closed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("not killed on timeout")
+	}
+	<-served
+	<-handled
+}
+
+func TestServerShutdownMethods(t *testing.T) {
+	serve := func() (*Server, chan error, *[]string) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := &Server{Timeout: time.Second, Server: &http.Server{Handler: NewApp()}, NoSignalHandling: true}
+		hooks := &[]string{}
+		srv.OnShutdown("jobs", func(ctx context.Context) error {
+			*hooks = append(*hooks, "jobs")
+			return nil
+		})
+		served := make(chan error)
+		go func() { served <- srv.Serve(l) }()
+		time.Sleep(50 * time.Millisecond)
+		return srv, served, hooks
+	}
+	wait := func(name string, served chan error) {
+		t.Helper()
+		select {
+		case err := <-served:
+			if err != nil {
+				t.Error(name, err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal(name, "Serve not returned")
+		}
+	}
+
+	// graceful, hooks run
+	srv, served, hooks := serve()
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+	wait("Shutdown", served)
+	if len(*hooks) != 1 {
+		t.Error("hooks not run")
+	}
+
+	// right now, or by embedded http.Server
+	srv, served, _ = serve()
+	srv.Close()
+	wait("Close", served)
+	srv, served, _ = serve()
+	srv.Server.Shutdown(context.Background())
+	wait("http.Server.Shutdown", served)
+}
+
+func TestLimitListener(t *testing.T) {
+	inner, _ := net.Listen("tcp", "127.0.0.1:0")
+	l := newLimitListener(inner, 1)
+	defer l.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+	first := <-accepted
+	select {
+	case <-accepted:
+		t.Fatal("accepted over limit")
+	case <-time.After(100 * time.Millisecond):
+	}
+	first.Close()
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("not accepted after release")
+	}
+}
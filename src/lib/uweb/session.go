@@ -78,6 +78,7 @@ func (m *SessMan) Handle(c *Context) int {
 		}
 	}
 	c.Sess = s
+	c.Set(CTX_SESS_KEY, s)
 
 	// next
 	c.Next()
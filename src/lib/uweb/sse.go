@@ -0,0 +1,189 @@
+package uweb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// comment sent to keep connection alive through proxies, 0 to disable
+	SSE_HEARTBEAT = 15 * time.Second
+
+	ErrStreamClosed = errors.New("EventStream: closed")
+)
+
+//
+// Server-Sent Events stream, see:
+// https://html.spec.whatwg.org/multipage/server-sent-events.html
+//
+// The handler keeps sending until Done, which is closed when client goes
+// away or Server begins graceful shutdown, then returns:
+//
+//	es, err := c.Res.EventStream(c.Req)
+//	if err != nil {
+//		return 500, err
+//	}
+//	defer es.Close()
+//	for {
+//		select {
+//		case <-es.Done():
+//			return 200, nil
+//		case msg := <-ch:
+//			es.Send("message", msg.Id, msg)
+//		}
+//	}
+//
+// Do not use MdDeadline on event stream routes, it ends at the deadline.
+//
+type EventStream struct {
+	// id of last event client received, to resume from
+	LastEventId string
+
+	res    *Response
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex // Send and heartbeat
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Start event stream, headers are sent and compress is bypassed.
+// Body, Status and Err of response are ignored after this.
+func (res *Response) EventStream(req *Request) (*EventStream, error) {
+	if res.sent() {
+		return nil, ErrStreamClosed
+	}
+
+	// headers, X-Accel-Buffering for nginx
+	h := res.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	res.Status = 200
+	res.WriteHeader(res.Status)
+	res.Flush()
+
+	// done on client gone or server shutdown
+	ctx, cancel := context.WithCancel(req.Context())
+	es := &EventStream{
+		LastEventId: req.Header.Get("Last-Event-ID"),
+		res:         res,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	es.wg.Add(1)
+	go es.watch(ShutdownNotify(req.Context()))
+
+	// stop heartbeat before response ends, also if handler forgot Close
+	res.OnEnd(es.Close)
+	return es, nil
+}
+
+// Closed when client goes away or server begins shutdown
+func (es *EventStream) Done() <-chan struct{} {
+	return es.ctx.Done()
+}
+
+// Send event, event and id are optional. data is sent as is if
+// string or []byte, or else json, multiple lines are split into
+// multiple data fields.
+func (es *EventStream) Send(event, id string, data interface{}) error {
+	var s string
+	switch v := data.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		s = string(b)
+	}
+
+	// fields
+	var buf strings.Builder
+	if len(event) > 0 {
+		fmt.Fprintf(&buf, "event: %s\n", oneLine(event))
+	}
+	if len(id) > 0 {
+		fmt.Fprintf(&buf, "id: %s\n", oneLine(id))
+	}
+	for _, line := range strings.Split(s, "\n") {
+		fmt.Fprintf(&buf, "data: %s\n", strings.TrimSuffix(line, "\r"))
+	}
+	buf.WriteString("\n")
+	return es.write(buf.String())
+}
+
+// Set client reconnection time
+func (es *EventStream) Retry(d time.Duration) error {
+	return es.write(fmt.Sprintf("retry: %d\n\n", d/time.Millisecond))
+}
+
+// Send comment, ignored by client
+func (es *EventStream) Comment(text string) error {
+	return es.write(fmt.Sprintf(": %s\n\n", oneLine(text)))
+}
+
+// Stop heartbeat, no more Send, the response ends when handler returns.
+// It's called when the response ends too.
+func (es *EventStream) Close() {
+	es.mu.Lock()
+	es.closed = true
+	es.mu.Unlock()
+	es.cancel()
+	es.wg.Wait()
+}
+
+// write and flush
+func (es *EventStream) write(s string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.closed {
+		return ErrStreamClosed
+	}
+	if _, err := es.res.Write([]byte(s)); err != nil {
+		es.cancel()
+		return err
+	}
+	es.res.Flush()
+	return nil
+}
+
+// heartbeat until done, cancel on shutdown
+func (es *EventStream) watch(shutdown <-chan struct{}) {
+	defer es.wg.Done()
+
+	var tick <-chan time.Time
+	if SSE_HEARTBEAT > 0 {
+		t := time.NewTicker(SSE_HEARTBEAT)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-es.ctx.Done():
+			return
+		case <-shutdown:
+			es.cancel()
+			return
+		case <-tick:
+			es.Comment("ping")
+		}
+	}
+}
+
+// field values can not have new lines
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
@@ -0,0 +1,87 @@
+package uweb
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventStream(t *testing.T) {
+	r := NewRouter()
+	r.Get("/events", func(c *Context) (int, error) {
+		es, err := c.Res.EventStream(c.Req)
+		if err != nil {
+			return 500, err
+		}
+		defer es.Close()
+		es.Send("", "", "hello")
+		es.Send("user", "2", map[string]int{"id": 2})
+		es.Send("multi", "", "a\nb")
+		es.Send("resume", "", es.LastEventId)
+
+		// until shutdown
+		select {
+		case <-es.Done():
+		case <-time.After(time.Second):
+			t.Error("not done on shutdown")
+		}
+		return 200, nil
+	})
+	app := NewApp()
+	app.Use(MdCompress())
+	app.Use(r)
+
+	srv := new(Server)
+	close(srv.closing())
+	ctx := context.WithValue(context.Background(), serverKey{}, srv)
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://localhost/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Last-Event-ID", "1")
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+
+	if w.Code != 200 || w.Header().Get("Content-Type") != "text/event-stream" || len(w.Header().Get("Content-Encoding")) > 0 {
+		t.Fatal(w.Code, w.Header())
+	}
+	want := "data: hello\n\n" +
+		"event: user\nid: 2\ndata: {\"id\":2}\n\n" +
+		"event: multi\ndata: a\ndata: b\n\n" +
+		"event: resume\ndata: 1\n\n"
+	if w.Body.String() != want {
+		t.Errorf("body %q, want %q", w.Body.String(), want)
+	}
+}
+
+func TestEventStreamNotClosed(t *testing.T) {
+	defer func(d time.Duration) { SSE_HEARTBEAT = d }(SSE_HEARTBEAT)
+	SSE_HEARTBEAT = time.Millisecond
+
+	r := NewRouter()
+	r.Get("/events", func(c *Context) (int, error) {
+		es, err := c.Res.EventStream(c.Req)
+		if err != nil {
+			return 500, err
+		}
+		es.Send("", "", "hello")
+		time.Sleep(10 * time.Millisecond)
+		return 200, nil
+	})
+	app := NewApp()
+	app.Use(r)
+
+	req, _ := http.NewRequest("GET", "http://localhost/events", nil)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	body := w.Body.String()
+	time.Sleep(10 * time.Millisecond)
+	if w.Body.String() != body {
+		t.Error("heartbeat after response ended")
+	}
+	if !strings.HasPrefix(body, "data: hello\n\n: ping\n\n") {
+		t.Errorf("body %q", body)
+	}
+}
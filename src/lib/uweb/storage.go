@@ -0,0 +1,170 @@
+package uweb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrStorageKey      = errors.New("Storage: bad key")
+	ErrStorageNotExist = errors.New("Storage: key not exist")
+)
+
+//
+// Storage of uploaded files, keys are slash separated
+// paths without "..", eg. "avatar/2f1c.png"
+//
+type Storage interface {
+	// Store r as key, size is -1 if unknown. Nothing is
+	// left if it fails, including r returns error.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+
+	// Read key, ErrStorageNotExist if not found
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Remove key, no error if not found
+	Delete(ctx context.Context, key string) error
+}
+
+// key should be relative and clean
+func checkKey(key string) error {
+	if len(key) == 0 || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return ErrStorageKey
+	}
+	for _, s := range strings.Split(key, "/") {
+		if len(s) == 0 || s == "." || s == ".." {
+			return ErrStorageKey
+		}
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// local
+
+//
+// Files in local dir
+//
+type LocalStorage struct {
+	dir string
+}
+
+// Create local storage, dir is created if not exist
+func NewLocalStorage(dir string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalStorage{dir}, nil
+}
+
+// @impl Storage.Put
+// write to temp file, then rename, so no partial file
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	dst := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), dst); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// @impl Storage.Get
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, ErrStorageNotExist
+	}
+	return f, err
+}
+
+// @impl Storage.Delete
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// -----------------------------------------------------------------------------
+// memory
+
+//
+// Files in memory, for tests and development
+//
+type MemStorage struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+// Create memory storage
+func NewMemStorage() *MemStorage {
+	return &MemStorage{
+		files: make(map[string][]byte),
+	}
+}
+
+// @impl Storage.Put
+func (s *MemStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.files[key] = data
+	s.mu.Unlock()
+	return nil
+}
+
+// @impl Storage.Get
+func (s *MemStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	data, ok := s.files[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrStorageNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// @impl Storage.Delete
+func (s *MemStorage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	delete(s.files, key)
+	s.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,154 @@
+package uweb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"lib/uuid"
+)
+
+var (
+	// max bytes of a non-file field of StreamFiles
+	UPLOAD_MAX_FIELD int64 = 1 << 20
+
+	ErrUploadField = &StatusError{413, errors.New("Upload: field too large")}
+)
+
+//
+// Result of a file saved by StreamFiles
+//
+type UploadedFile struct {
+	Field       string `json:"field"`        // form field name
+	Name        string `json:"name"`         // original filename, base only
+	Key         string `json:"key"`          // key in storage
+	Size        int64  `json:"size"`         // bytes
+	ContentType string `json:"content_type"` // sniffed from content
+	Sha256      string `json:"sha256"`       // hex
+}
+
+//
+// Stream multipart body straight to storage, without buffering files
+// in memory or temp files as ParseMultipartForm does. Files are stored
+// under prefix with uuid names, other fields are returned as values.
+// opts is optional, if any file fails, files stored are deleted.
+//
+//	files, form, err := c.Req.StreamFiles(store, "avatar/", &uweb.UploadOpts{
+//		MaxSize: 10 << 20,
+//		Types:   []string{"image/*"},
+//	})
+//
+func (r *Request) StreamFiles(store Storage, prefix string, opts *UploadOpts) ([]*UploadedFile, url.Values, error) {
+	if opts == nil {
+		opts = new(UploadOpts)
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, &StatusError{400, err}
+	}
+
+	var files []*UploadedFile
+	form := url.Values{}
+	fail := func(err error) ([]*UploadedFile, url.Values, error) {
+		for _, f := range files {
+			store.Delete(r.Context(), f.Key)
+		}
+		return nil, nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		// value
+		if len(part.FileName()) == 0 {
+			b, err := io.ReadAll(io.LimitReader(part, UPLOAD_MAX_FIELD+1))
+			part.Close()
+			if err != nil {
+				return fail(err)
+			}
+			if int64(len(b)) > UPLOAD_MAX_FIELD {
+				return fail(ErrUploadField)
+			}
+			form.Add(part.FormName(), string(b))
+			continue
+		}
+
+		// file
+		if opts.MaxFiles > 0 && len(files) >= opts.MaxFiles {
+			part.Close()
+			return fail(ErrUploadTooMany)
+		}
+		f, err := streamFile(r, store, prefix, part.FormName(), path.Base(part.FileName()), part, opts)
+		part.Close()
+		if err != nil {
+			return fail(err)
+		}
+		files = append(files, f)
+	}
+	return files, form, nil
+}
+
+// sniff, check, then store with size limit and hash
+func streamFile(r *Request, store Storage, prefix, field, name string, src io.Reader, opts *UploadOpts) (*UploadedFile, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+	if !opts.allow(name, head) {
+		return nil, ErrUploadType
+	}
+	ct := http.DetectContentType(head)
+
+	// store
+	cr := &uploadReader{
+		r:   io.MultiReader(bytes.NewReader(head), src),
+		max: opts.MaxSize,
+		sum: sha256.New(),
+	}
+	key := prefix + uuid.New() + strings.ToLower(path.Ext(name))
+	if err := store.Put(r.Context(), key, cr, -1, ct); err != nil {
+		return nil, err
+	}
+	return &UploadedFile{
+		Field:       field,
+		Name:        name,
+		Key:         key,
+		Size:        cr.n,
+		ContentType: ct,
+		Sha256:      hex.EncodeToString(cr.sum.Sum(nil)),
+	}, nil
+}
+
+//
+// Count, hash and limit file being stored
+//
+type uploadReader struct {
+	r   io.Reader
+	max int64 // 0 no limit
+	n   int64
+	sum hash.Hash
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	u.n += int64(n)
+	u.sum.Write(p[:n])
+	if u.max > 0 && u.n > u.max {
+		return n, ErrUploadTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,431 @@
+package uweb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// message types, opcodes of RFC 6455
+const (
+	WS_CONTINUATION = 0
+	WS_TEXT         = 1
+	WS_BINARY       = 2
+	WS_CLOSE        = 8
+	WS_PING         = 9
+	WS_PONG         = 10
+)
+
+// close codes
+const (
+	WS_CLOSE_NORMAL         = 1000
+	WS_CLOSE_GOING_AWAY     = 1001
+	WS_CLOSE_PROTOCOL_ERROR = 1002
+	WS_CLOSE_UNSUPPORTED    = 1003
+	WS_CLOSE_NO_STATUS      = 1005
+	WS_CLOSE_INVALID_DATA   = 1007
+	WS_CLOSE_TOO_BIG        = 1009
+	WS_CLOSE_INTERNAL       = 1011
+)
+
+var (
+	// max bytes of a message, fragments joined
+	WS_MAX_MESSAGE = 1 << 20
+
+	// server pings to keep connection alive, 0 to disable
+	WS_PING_INTERVAL = 30 * time.Second
+
+	// a write blocks no longer than it
+	WS_WRITE_TIMEOUT = 10 * time.Second
+
+	// check Origin header, default allows same host or no Origin,
+	// host is the one client requested, see SetTrustedProxies
+	WS_CHECK_ORIGIN = wsSameOrigin
+
+	ErrWSHandshake = errors.New("WebSocket: bad handshake")
+	ErrWSVersion   = errors.New("WebSocket: unsupported version")
+	ErrWSOrigin    = errors.New("WebSocket: origin not allowed")
+	ErrWSClosed    = errors.New("WebSocket: closed")
+)
+
+// GUID for Sec-WebSocket-Accept
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+//
+// WebSocket handler, runs after upgrade. The conn is closed
+// when it returns, c.Res should not be used.
+//
+type WSHandler func(c *Context, conn *WSConn)
+
+// WebSocket route of GET, middlewares such as session and
+// auth run before upgrade, as other routes
+func WS(p string, h WSHandler) *Route {
+	return defaultRouter.WS(p, h)
+}
+
+func (r *Router) WS(p string, h WSHandler) *Route {
+	rt := r.addHandler("GET", p, h.serve)
+	rt.hname = funcName(h) // not serve-fm
+	return rt
+}
+
+// upgrade and run
+func (h WSHandler) serve(c *Context) (int, error) {
+	conn, status, err := wsUpgrade(c)
+	if err != nil {
+		return status, err
+	}
+	// on panic too, only the first close works, MdRecover
+	// can not write on hijacked conn, End skips it
+	defer conn.Close(WS_CLOSE_INTERNAL, "")
+	h(c, conn)
+	conn.Close(WS_CLOSE_NORMAL, "")
+	return http.StatusSwitchingProtocols, nil
+}
+
+// handshake, hijack and create conn
+func wsUpgrade(c *Context) (*WSConn, int, error) {
+	req := c.Req
+	if !headerHasToken(req.Header, "Connection", "upgrade") || !headerHasToken(req.Header, "Upgrade", "websocket") {
+		return nil, http.StatusBadRequest, ErrWSHandshake
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		c.Res.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, http.StatusUpgradeRequired, ErrWSVersion
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		return nil, http.StatusBadRequest, ErrWSHandshake
+	}
+	if WS_CHECK_ORIGIN != nil && !WS_CHECK_ORIGIN(req) {
+		return nil, http.StatusForbidden, ErrWSOrigin
+	}
+
+	// hijack
+	netConn, brw, err := http.NewResponseController(c.Res).Hijack()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	netConn.SetDeadline(time.Time{})
+
+	// response, with headers set by middlewares such as Set-Cookie
+	sum := sha1.Sum([]byte(key + wsGUID))
+	buf := new(bytes.Buffer)
+	buf.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	buf.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\n")
+	buf.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n")
+	h := c.Res.Header().Clone()
+	for _, k := range []string{"Connection", "Upgrade", "Content-Type", "Content-Length", "Content-Encoding", "Transfer-Encoding"} {
+		h.Del(k)
+	}
+	h.Write(buf)
+	buf.WriteString("\r\n")
+	netConn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+	if _, err := netConn.Write(buf.Bytes()); err != nil {
+		netConn.Close()
+		return nil, http.StatusSwitchingProtocols, err
+	}
+
+	// conn
+	conn := &WSConn{
+		conn:    netConn,
+		br:      brw.Reader,
+		done:    make(chan struct{}),
+		untrack: trackHijacked(req.Context(), netConn),
+	}
+	go conn.watch(ShutdownNotify(req.Context()))
+	return conn, http.StatusSwitchingProtocols, nil
+}
+
+// allow no Origin, or Origin host equals Host, forwarded one if by proxy
+func wsSameOrigin(r *Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// comma separated header has token, case insensitive
+func headerHasToken(h http.Header, key, token string) bool {
+	for _, v := range h[key] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//
+// WSCloseError is returned by ReadMessage after close
+//
+type WSCloseError struct {
+	Code int
+	Text string
+}
+
+func (e *WSCloseError) Error() string {
+	return fmt.Sprintf("WebSocket: closed %d %s", e.Code, e.Text)
+}
+
+//
+// WebSocket connection, one reader and many writers
+// can use it at the same time
+//
+type WSConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	wmu       sync.Mutex // writes
+	closeSent bool
+
+	closeOnce sync.Once
+	closeErr  error
+	done      chan struct{}
+	untrack   func()
+}
+
+// Closed after conn is closed, by either side or shutdown
+func (c *WSConn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Read deadline of next message, pings of server keep client
+// answering pongs, so it's safe to set a few ping intervals
+func (c *WSConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// Read a text or binary message, pings are answered. After close
+// the error is *WSCloseError with the code.
+func (c *WSConn) ReadMessage() (int, []byte, error) {
+	op := 0
+	var msg []byte
+	for {
+		fin, fop, data, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch fop {
+		case WS_PING:
+			c.writeFrame(WS_PONG, data)
+			continue
+		case WS_PONG:
+			continue
+		case WS_CLOSE:
+			code, text := WS_CLOSE_NO_STATUS, ""
+			if len(data) >= 2 {
+				code, text = int(binary.BigEndian.Uint16(data)), string(data[2:])
+			}
+			c.Close(code, text)
+			return 0, nil, c.closeErr
+		case WS_CONTINUATION:
+			if op == 0 {
+				return 0, nil, c.fail(WS_CLOSE_PROTOCOL_ERROR, "unexpected continuation")
+			}
+			msg = append(msg, data...)
+		case WS_TEXT, WS_BINARY:
+			if op != 0 {
+				return 0, nil, c.fail(WS_CLOSE_PROTOCOL_ERROR, "expect continuation")
+			}
+			op, msg = fop, data
+		default:
+			return 0, nil, c.fail(WS_CLOSE_PROTOCOL_ERROR, "unknown opcode")
+		}
+		if len(msg) > WS_MAX_MESSAGE {
+			return 0, nil, c.fail(WS_CLOSE_TOO_BIG, "message too big")
+		}
+		if fin {
+			if op == WS_TEXT && !utf8.Valid(msg) {
+				return 0, nil, c.fail(WS_CLOSE_INVALID_DATA, "invalid utf8")
+			}
+			return op, msg, nil
+		}
+	}
+}
+
+// Write a text or binary message
+func (c *WSConn) WriteMessage(op int, data []byte) error {
+	if op != WS_TEXT && op != WS_BINARY {
+		return errors.New("WebSocket: bad message type")
+	}
+	return c.writeFrame(op, data)
+}
+
+// Write text message
+func (c *WSConn) WriteText(s string) error {
+	return c.writeFrame(WS_TEXT, []byte(s))
+}
+
+// Write json as text message
+func (c *WSConn) WriteJson(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(WS_TEXT, b)
+}
+
+// Ping client, pong is handled by ReadMessage
+func (c *WSConn) Ping(data []byte) error {
+	return c.writeFrame(WS_PING, data)
+}
+
+// Send close frame and close conn, only the first call works
+func (c *WSConn) Close(code int, text string) error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.closeErr = &WSCloseError{code, text}
+
+		// no status is not allowed on wire
+		var data []byte
+		if code != WS_CLOSE_NO_STATUS {
+			data = make([]byte, 2, 2+len(text))
+			binary.BigEndian.PutUint16(data, uint16(code))
+			data = append(data, text...)
+		}
+		err = c.writeFrame(WS_CLOSE, data)
+
+		c.wmu.Lock()
+		c.closeSent = true
+		c.wmu.Unlock()
+		c.conn.Close()
+		close(c.done)
+		c.untrack()
+	})
+	return err
+}
+
+// close on protocol error
+func (c *WSConn) fail(code int, text string) error {
+	c.Close(code, text)
+	return c.closeErr
+}
+
+// read a frame, client frames must be masked
+func (c *WSConn) readFrame() (bool, int, []byte, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+		return false, 0, nil, c.readErr(err)
+	}
+	fin, op := b[0]&0x80 != 0, int(b[0]&0x0f)
+	if b[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(WS_CLOSE_PROTOCOL_ERROR, "reserved bits set")
+	}
+	if b[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(WS_CLOSE_PROTOCOL_ERROR, "frame not masked")
+	}
+
+	// length
+	n := uint64(b[1] & 0x7f)
+	if op >= WS_CLOSE && (!fin || n > 125) {
+		return false, 0, nil, c.fail(WS_CLOSE_PROTOCOL_ERROR, "bad control frame")
+	}
+	switch n {
+	case 126:
+		if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+			return false, 0, nil, c.readErr(err)
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.br, b[:8]); err != nil {
+			return false, 0, nil, c.readErr(err)
+		}
+		n = binary.BigEndian.Uint64(b[:8])
+	}
+	if n > uint64(WS_MAX_MESSAGE) {
+		return false, 0, nil, c.fail(WS_CLOSE_TOO_BIG, "message too big")
+	}
+
+	// mask and payload
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, c.readErr(err)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c.br, data); err != nil {
+		return false, 0, nil, c.readErr(err)
+	}
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+	return fin, op, data, nil
+}
+
+// after closed by us, read fails with the close reason
+func (c *WSConn) readErr(err error) error {
+	select {
+	case <-c.done:
+		return c.closeErr
+	default:
+		return err
+	}
+}
+
+// write an unmasked frame
+func (c *WSConn) writeFrame(op int, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrWSClosed
+	}
+
+	// header
+	buf := make([]byte, 0, 10+len(data))
+	buf = append(buf, 0x80|byte(op))
+	switch n := len(data); {
+	case n <= 125:
+		buf = append(buf, byte(n))
+	case n <= 0xffff:
+		buf = append(buf, 126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, 127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	buf = append(buf, data...)
+
+	c.conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// ping until closed, close on server shutdown
+func (c *WSConn) watch(shutdown <-chan struct{}) {
+	var tick <-chan time.Time
+	if WS_PING_INTERVAL > 0 {
+		t := time.NewTicker(WS_PING_INTERVAL)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-shutdown:
+			c.Close(WS_CLOSE_GOING_AWAY, "server shutdown")
+			return
+		case <-tick:
+			c.Ping(nil)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package uweb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// write a masked client frame
+func testWSWrite(t *testing.T, conn net.Conn, op int, data string) {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | byte(op), 0x80 | byte(len(data))}
+	frame = append(frame, mask...)
+	for i := 0; i < len(data); i++ {
+		frame = append(frame, data[i]^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// read a server frame, short ones only
+func testWSRead(t *testing.T, br *bufio.Reader) (int, string) {
+	var b [2]byte
+	if _, err := io.ReadFull(br, b[:]); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, b[1]&0x7f)
+	if _, err := io.ReadFull(br, data); err != nil {
+		t.Fatal(err)
+	}
+	return int(b[0] & 0x0f), string(data)
+}
+
+func TestWebSocket(t *testing.T) {
+	closed := make(chan error, 1)
+	r := NewRouter()
+	r.Group("/auth", &testGuard{401}).WS("/chat", nil)
+	r.WS("/chat", func(c *Context, conn *WSConn) {
+		for {
+			op, msg, err := conn.ReadMessage()
+			if err != nil {
+				closed <- err
+				return
+			}
+			conn.WriteMessage(op, append([]byte("echo "), msg...))
+		}
+	})
+	app := NewApp()
+	app.Use(MdCompress())
+	app.Use(r)
+	served := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		app.ServeHTTP(w, req)
+		close(served)
+	}))
+	defer ts.Close()
+
+	// middlewares run before upgrade, and plain request is refused
+	if w := testServe(r, "GET", "http://localhost/auth/chat"); w.Code != 401 {
+		t.Errorf("guard: got %d", w.Code)
+	}
+	if w := testServe(r, "GET", "http://localhost/chat"); w.Code != 400 {
+		t.Errorf("no upgrade: got %d", w.Code)
+	}
+
+	// handshake, example of RFC 6455
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /chat HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\nAccept-Encoding: gzip\r\n\r\n")
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 101 || res.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatal(res.StatusCode, res.Header)
+	}
+
+	// echo, ping
+	testWSWrite(t, conn, WS_TEXT, "hi")
+	if op, s := testWSRead(t, br); op != WS_TEXT || s != "echo hi" {
+		t.Errorf("echo: %d %q", op, s)
+	}
+	testWSWrite(t, conn, WS_PING, "p")
+	if op, s := testWSRead(t, br); op != WS_PONG || s != "p" {
+		t.Errorf("pong: %d %q", op, s)
+	}
+
+	// close is echoed
+	testWSWrite(t, conn, WS_CLOSE, "\x03\xe8bye")
+	op, s := testWSRead(t, br)
+	if op != WS_CLOSE || binary.BigEndian.Uint16([]byte(s)) != WS_CLOSE_NORMAL {
+		t.Errorf("close: %d %q", op, s)
+	}
+	closeErr := <-closed
+	if e, ok := closeErr.(*WSCloseError); !ok || e.Code != WS_CLOSE_NORMAL || e.Text != "bye" {
+		t.Errorf("close err: %v", closeErr)
+	}
+	<-served
+}
+
+func TestWebSocketPanic(t *testing.T) {
+	r := NewRouter()
+	r.WS("/chat", func(c *Context, conn *WSConn) {
+		panic("broken handler")
+	})
+	app := NewApp()
+	app.Use(MdRecover(nil))
+	app.Use(r)
+	served := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		app.ServeHTTP(w, req)
+		close(served)
+	}))
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /chat HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil || res.StatusCode != 101 {
+		t.Fatal(res, err)
+	}
+
+	// close frame, then eof, no 500 written on conn
+	op, s := testWSRead(t, br)
+	if op != WS_CLOSE || binary.BigEndian.Uint16([]byte(s)) != WS_CLOSE_INTERNAL {
+		t.Errorf("close: %d %q", op, s)
+	}
+	if b, err := io.ReadAll(br); err != nil || len(b) > 0 {
+		t.Errorf("after close: %q %v", b, err)
+	}
+	<-served
+}